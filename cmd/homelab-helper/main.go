@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/benfiola/homelab-helper/internal/gatewaycontroller"
 	"github.com/benfiola/homelab-helper/internal/info"
@@ -118,12 +121,16 @@ func main() {
 						Name:    "address",
 						Sources: cli.EnvVars("ADDRESS"),
 					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Sources: cli.EnvVars("INTERVAL"),
+					},
 					&cli.BoolFlag{
 						Name:    "run-forever",
 						Value:   true,
 						Sources: cli.EnvVars("RUN_FOREVER"),
 					},
-					&cli.StringFlag{
+					&cli.StringSliceFlag{
 						Name:     "unseal-key-path",
 						Required: true,
 						Sources:  cli.EnvVars("UNSEAL_KEY_PATH"),
@@ -131,13 +138,15 @@ func main() {
 				},
 				Action: func(ctx context.Context, c *cli.Command) error {
 					address := c.String("address")
+					interval := c.Duration("interval")
 					runForever := c.Bool("run-forever")
-					unsealKeyPath := c.String("unseal-key-path")
+					unsealKeyPaths := c.StringSlice("unseal-key-path")
 
 					unsealer, err := vaultunseal.New(&vaultunseal.Opts{
-						Address:       address,
-						RunForever:    ptr.Get(runForever),
-						UnsealKeyPath: unsealKeyPath,
+						Address:        address,
+						Interval:       interval,
+						RunForever:     ptr.Get(runForever),
+						UnsealKeyPaths: unsealKeyPaths,
 					})
 					if err != nil {
 						return err
@@ -145,17 +154,232 @@ func main() {
 					return unsealer.Run(ctx)
 				},
 			},
+			{
+				Name: "vault-diff-secrets",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "address",
+						Value:   "http://localhost:8200",
+						Sources: cli.EnvVars("ADDRESS"),
+					},
+					&cli.StringFlag{
+						Name:    "age-identity-path",
+						Sources: cli.EnvVars("AGE_IDENTITY_PATH"),
+					},
+					&cli.StringFlag{
+						Name:    "backup-version",
+						Sources: cli.EnvVars("BACKUP_VERSION"),
+					},
+					&cli.IntFlag{
+						Name:    "concurrency",
+						Value:   4,
+						Sources: cli.EnvVars("CONCURRENCY"),
+					},
+					&cli.StringFlag{
+						Name:    "encryption-key-path",
+						Sources: cli.EnvVars("ENCRYPTION_KEY_PATH"),
+					},
+					&lineSliceFlag{
+						Name:    "exclude",
+						Usage:   "path rule to skip; repeat the flag or separate rules with newlines, since rules may contain commas",
+						Sources: cli.EnvVars("EXCLUDE"),
+					},
+					&lineSliceFlag{
+						Name:    "include",
+						Usage:   "path rule to select; repeat the flag or separate rules with newlines, since rules may contain commas",
+						Sources: cli.EnvVars("INCLUDE"),
+					},
+					&cli.StringFlag{
+						Name:    "layout",
+						Value:   string(vaultpush.LayoutSingle),
+						Sources: cli.EnvVars("LAYOUT"),
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Value:   string(vaultpush.DiffOutputText),
+						Sources: cli.EnvVars("OUTPUT"),
+					},
+					&cli.StringSliceFlag{
+						Name:     "secrets-path",
+						Required: true,
+						Sources:  cli.EnvVars("SECRETS_PATH"),
+					},
+					&cli.StringFlag{
+						Name:     "storage-path",
+						Required: true,
+						Sources:  cli.EnvVars("STORAGE_PATH"),
+					},
+					&cli.StringFlag{
+						Name:    "storage-credentials-path",
+						Sources: cli.EnvVars("STORAGE_CREDENTIALS_PATH"),
+					},
+				}, vaultAuthFlags()...),
+				Action: func(ctx context.Context, c *cli.Command) error {
+					address := c.String("address")
+					ageIdentityPath := c.String("age-identity-path")
+					backupVersion := c.String("backup-version")
+					concurrency := c.Int("concurrency")
+					encryptionKeyPath := c.String("encryption-key-path")
+					exclude := c.StringSlice("exclude")
+					include := c.StringSlice("include")
+					layout := c.String("layout")
+					output := c.String("output")
+					secretsPaths := c.StringSlice("secrets-path")
+					storagePath := c.String("storage-path")
+					storageCredentialsPath := c.String("storage-credentials-path")
+
+					differ, err := vaultpush.NewDiffer(&vaultpush.DifferOpts{
+						Address:                address,
+						AgeIdentityPath:        ageIdentityPath,
+						Auth:                   vaultAuthOpts(c),
+						Concurrency:            concurrency,
+						EncryptionKeyPath:      encryptionKeyPath,
+						Exclude:                exclude,
+						Include:                include,
+						Layout:                 vaultpush.Layout(layout),
+						Output:                 vaultpush.DiffOutput(output),
+						SecretsPaths:           secretsPaths,
+						StoragePath:            storagePath,
+						StorageCredentialsPath: storageCredentialsPath,
+						Version:                backupVersion,
+						Writer:                 c.Root().Writer,
+					})
+					if err != nil {
+						return err
+					}
+
+					err = differ.Run(ctx)
+					if errors.Is(err, vaultpush.ErrDrift) {
+						return cli.Exit("", 2)
+					}
+					return err
+				},
+			},
 			{
 				Name: "vault-push-secrets",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "address",
 						Value:   "http://localhost:8200",
 						Sources: cli.EnvVars("ADDRESS"),
 					},
+					&cli.StringSliceFlag{
+						Name:    "age-recipient",
+						Usage:   "age recipient to encrypt backups for; checksums are not stored with age encryption, so every restart uploads the backup again (and writes a new version with --versioned)",
+						Sources: cli.EnvVars("AGE_RECIPIENTS"),
+					},
+					&cli.StringFlag{
+						Name:    "change-detection",
+						Value:   string(vaultpush.ChangeDetectionChecksum),
+						Sources: cli.EnvVars("CHANGE_DETECTION"),
+					},
+					&cli.IntFlag{
+						Name:    "concurrency",
+						Value:   4,
+						Sources: cli.EnvVars("CONCURRENCY"),
+					},
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Sources: cli.EnvVars("DRY_RUN"),
+					},
+					&cli.StringFlag{
+						Name:    "encryption-key-path",
+						Sources: cli.EnvVars("ENCRYPTION_KEY_PATH"),
+					},
+					&lineSliceFlag{
+						Name:    "exclude",
+						Usage:   "path rule to skip; repeat the flag or separate rules with newlines, since rules may contain commas",
+						Sources: cli.EnvVars("EXCLUDE"),
+					},
+					&cli.BoolFlag{
+						Name:    "export-config",
+						Sources: cli.EnvVars("EXPORT_CONFIG"),
+					},
+					&cli.BoolFlag{
+						Name:    "export-metadata",
+						Sources: cli.EnvVars("EXPORT_METADATA"),
+					},
+					&cli.IntFlag{
+						Name:    "export-versions",
+						Sources: cli.EnvVars("EXPORT_VERSIONS"),
+					},
+					&cli.BoolFlag{
+						Name:    "fail-fast",
+						Sources: cli.EnvVars("FAIL_FAST"),
+					},
+					&cli.StringFlag{
+						Name:    "format",
+						Usage:   "backup format (dotenv, json, sops, yaml); dotenv is export-only and cannot be read by vault-restore-secrets or vault-diff-secrets; sops stores no checksum, so every restart uploads the backup again (and writes a new version with --versioned)",
+						Value:   string(vaultpush.FormatYAML),
+						Sources: cli.EnvVars("FORMAT"),
+					},
 					&cli.StringFlag{
-						Name:    "role",
-						Sources: cli.EnvVars("ROLE"),
+						Name:    "health-address",
+						Value:   ":8081",
+						Sources: cli.EnvVars("HEALTH_ADDRESS"),
+					},
+					&lineSliceFlag{
+						Name:    "include",
+						Usage:   "path rule to select; repeat the flag or separate rules with newlines, since rules may contain commas",
+						Sources: cli.EnvVars("INCLUDE"),
+					},
+					&cli.DurationFlag{
+						Name:    "interval",
+						Sources: cli.EnvVars("INTERVAL"),
+					},
+					&cli.DurationFlag{
+						Name:    "jitter",
+						Sources: cli.EnvVars("JITTER"),
+					},
+					&cli.StringFlag{
+						Name:    "layout",
+						Value:   string(vaultpush.LayoutSingle),
+						Sources: cli.EnvVars("LAYOUT"),
+					},
+					&cli.IntFlag{
+						Name:    "max-failures",
+						Value:   5,
+						Sources: cli.EnvVars("MAX_FAILURES"),
+					},
+					&cli.StringFlag{
+						Name:    "mirror-instance",
+						Sources: cli.EnvVars("MIRROR_INSTANCE"),
+					},
+					&lineSliceFlag{
+						Name:    "mirror-rule",
+						Usage:   "mirror rule; repeat the flag or separate rules with newlines, since rules may contain commas",
+						Sources: cli.EnvVars("MIRROR_RULES"),
+					},
+					&lineSliceFlag{
+						Name:    "notify",
+						Usage:   "notification url; repeat the flag or separate urls with newlines, since urls may contain commas",
+						Sources: cli.EnvVars("NOTIFY"),
+					},
+					&cli.DurationFlag{
+						Name:    "notify-interval",
+						Sources: cli.EnvVars("NOTIFY_INTERVAL"),
+					},
+					&cli.IntFlag{
+						Name:    "ready-intervals",
+						Value:   3,
+						Sources: cli.EnvVars("READY_INTERVALS"),
+					},
+					&cli.IntFlag{
+						Name:    "retain-daily",
+						Sources: cli.EnvVars("RETAIN_DAILY"),
+					},
+					&cli.IntFlag{
+						Name:    "retain-last",
+						Sources: cli.EnvVars("RETAIN_LAST"),
+					},
+					&cli.DurationFlag{
+						Name:    "retry-initial-delay",
+						Value:   10 * time.Second,
+						Sources: cli.EnvVars("RETRY_INITIAL_DELAY"),
+					},
+					&cli.DurationFlag{
+						Name:    "retry-max-delay",
+						Sources: cli.EnvVars("RETRY_MAX_DELAY"),
 					},
 					&cli.BoolFlag{
 						Name:    "run-forever",
@@ -163,9 +387,16 @@ func main() {
 						Sources: cli.EnvVars("RUN_FOREVER"),
 					},
 					&cli.StringFlag{
-						Name:     "secrets-path",
-						Required: true,
-						Sources:  cli.EnvVars("SECRETS_PATH"),
+						Name:    "schedule",
+						Sources: cli.EnvVars("SCHEDULE"),
+					},
+					&cli.StringSliceFlag{
+						Name:    "secrets-path",
+						Sources: cli.EnvVars("SECRETS_PATH"),
+					},
+					&cli.BoolFlag{
+						Name:    "snapshot",
+						Sources: cli.EnvVars("SNAPSHOT"),
 					},
 					&cli.StringFlag{
 						Name:     "storage-path",
@@ -173,32 +404,96 @@ func main() {
 						Sources:  cli.EnvVars("STORAGE_PATH"),
 					},
 					&cli.StringFlag{
-						Name:     "storage-credentials-path",
-						Required: true,
-						Sources:  cli.EnvVars("STORAGE_CREDENTIALS_PATH"),
+						Name:    "storage-credentials-path",
+						Sources: cli.EnvVars("STORAGE_CREDENTIALS_PATH"),
 					},
 					&cli.StringFlag{
-						Name:    "token",
-						Sources: cli.EnvVars("TOKEN"),
+						Name:    "timezone",
+						Sources: cli.EnvVars("TIMEZONE"),
 					},
-				},
+					&cli.BoolFlag{
+						Name:    "verify",
+						Value:   true,
+						Sources: cli.EnvVars("VERIFY"),
+					},
+					&cli.BoolFlag{
+						Name:    "versioned",
+						Sources: cli.EnvVars("VERSIONED"),
+					},
+				}, vaultAuthFlags()...),
 				Action: func(ctx context.Context, c *cli.Command) error {
 					address := c.String("address")
-					role := c.String("role")
+					ageRecipients := c.StringSlice("age-recipient")
+					changeDetection := c.String("change-detection")
+					concurrency := c.Int("concurrency")
+					dryRun := c.Bool("dry-run")
+					encryptionKeyPath := c.String("encryption-key-path")
+					exclude := c.StringSlice("exclude")
+					exportConfig := c.Bool("export-config")
+					exportMetadata := c.Bool("export-metadata")
+					exportVersions := c.Int("export-versions")
+					failFast := c.Bool("fail-fast")
+					format := c.String("format")
+					healthAddress := c.String("health-address")
+					include := c.StringSlice("include")
+					interval := c.Duration("interval")
+					jitter := c.Duration("jitter")
+					layout := c.String("layout")
+					maxFailures := c.Int("max-failures")
+					mirrorInstance := c.String("mirror-instance")
+					mirrorRules := c.StringSlice("mirror-rule")
+					notify := c.StringSlice("notify")
+					notifyInterval := c.Duration("notify-interval")
+					readyIntervals := c.Int("ready-intervals")
+					retainDaily := c.Int("retain-daily")
+					retainLast := c.Int("retain-last")
+					retryInitialDelay := c.Duration("retry-initial-delay")
+					retryMaxDelay := c.Duration("retry-max-delay")
 					runForever := c.Bool("run-forever")
-					secretsPath := c.String("secrets-path")
+					schedule := c.String("schedule")
+					secretsPaths := c.StringSlice("secrets-path")
+					snapshot := c.Bool("snapshot")
 					storagePath := c.String("storage-path")
 					storageCredentialsPath := c.String("storage-credentials-path")
-					token := c.String("token")
+					timezone := c.String("timezone")
+					verify := c.Bool("verify")
+					versioned := c.Bool("versioned")
 
 					pusher, err := vaultpush.New(&vaultpush.Opts{
 						Address:                address,
-						Role:                   role,
+						AgeRecipients:          ageRecipients,
+						Auth:                   vaultAuthOpts(c),
+						ChangeDetection:        vaultpush.ChangeDetection(changeDetection),
+						Concurrency:            concurrency,
+						DryRun:                 dryRun,
+						EncryptionKeyPath:      encryptionKeyPath,
+						Exclude:                exclude,
+						ExportConfig:           exportConfig,
+						ExportMetadata:         exportMetadata,
+						ExportVersions:         exportVersions,
+						FailFast:               failFast,
+						Format:                 vaultpush.Format(format),
+						HealthAddress:          healthAddress,
+						Include:                include,
+						Interval:               interval,
+						Jitter:                 jitter,
+						Layout:                 vaultpush.Layout(layout),
+						MirrorInstance:         mirrorInstance,
+						MirrorRules:            mirrorRules,
+						Notify:                 notify,
+						NotifyInterval:         notifyInterval,
+						ReadyIntervals:         readyIntervals,
+						Retention:              vaultpush.Retention{Daily: retainDaily, Last: retainLast},
+						Retry:                  vaultpush.Retry{InitialDelay: retryInitialDelay, MaxDelay: retryMaxDelay, MaxFailures: maxFailures},
 						RunForever:             ptr.Get(runForever),
-						SecretsPath:            secretsPath,
+						Schedule:               schedule,
+						SecretsPaths:           secretsPaths,
+						Snapshot:               snapshot,
 						StoragePath:            storagePath,
 						StorageCredentialsPath: storageCredentialsPath,
-						Token:                  token,
+						Timezone:               timezone,
+						VerifyUploads:          ptr.Get(verify),
+						Versioned:              versioned,
 					})
 					if err != nil {
 						return err
@@ -207,6 +502,93 @@ func main() {
 					return pusher.Run(ctx)
 				},
 			},
+			{
+				Name: "vault-restore-secrets",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "address",
+						Value:   "http://localhost:8200",
+						Sources: cli.EnvVars("ADDRESS"),
+					},
+					&cli.StringFlag{
+						Name:    "age-identity-path",
+						Sources: cli.EnvVars("AGE_IDENTITY_PATH"),
+					},
+					&cli.StringSliceFlag{
+						Name:    "app",
+						Sources: cli.EnvVars("APPS"),
+					},
+					&cli.StringFlag{
+						Name:    "backup-version",
+						Sources: cli.EnvVars("BACKUP_VERSION"),
+					},
+					&cli.BoolFlag{
+						Name:    "config",
+						Sources: cli.EnvVars("RESTORE_CONFIG"),
+					},
+					&cli.StringFlag{
+						Name:    "encryption-key-path",
+						Sources: cli.EnvVars("ENCRYPTION_KEY_PATH"),
+					},
+					&cli.StringFlag{
+						Name:    "layout",
+						Value:   string(vaultpush.LayoutSingle),
+						Sources: cli.EnvVars("LAYOUT"),
+					},
+					&cli.StringFlag{
+						Name:    "mode",
+						Value:   string(vaultpush.RestoreModeSkip),
+						Sources: cli.EnvVars("MODE"),
+					},
+					&cli.StringFlag{
+						Name:    "secrets-path",
+						Usage:   "mount to restore into; a single-mount backup is written into this mount and a multi-mount backup is restricted to it (required for legacy backups)",
+						Sources: cli.EnvVars("SECRETS_PATH"),
+					},
+					&cli.StringFlag{
+						Name:     "storage-path",
+						Required: true,
+						Sources:  cli.EnvVars("STORAGE_PATH"),
+					},
+					&cli.StringFlag{
+						Name:    "storage-credentials-path",
+						Sources: cli.EnvVars("STORAGE_CREDENTIALS_PATH"),
+					},
+				}, vaultAuthFlags()...),
+				Action: func(ctx context.Context, c *cli.Command) error {
+					address := c.String("address")
+					ageIdentityPath := c.String("age-identity-path")
+					apps := c.StringSlice("app")
+					backupVersion := c.String("backup-version")
+					config := c.Bool("config")
+					encryptionKeyPath := c.String("encryption-key-path")
+					layout := c.String("layout")
+					mode := c.String("mode")
+					secretsPath := c.String("secrets-path")
+					storagePath := c.String("storage-path")
+					storageCredentialsPath := c.String("storage-credentials-path")
+
+					restorer, err := vaultpush.NewRestorer(&vaultpush.RestorerOpts{
+						Address:                address,
+						AgeIdentityPath:        ageIdentityPath,
+						Apps:                   apps,
+						Auth:                   vaultAuthOpts(c),
+						Config:                 config,
+						EncryptionKeyPath:      encryptionKeyPath,
+						Layout:                 vaultpush.Layout(layout),
+						Mode:                   vaultpush.RestoreMode(mode),
+						SecretsPath:            secretsPath,
+						StoragePath:            storagePath,
+						StorageCredentialsPath: storageCredentialsPath,
+						Version:                backupVersion,
+					})
+					if err != nil {
+						return err
+					}
+
+					return restorer.Run(ctx)
+				},
+			},
 		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -226,8 +608,100 @@ func main() {
 	err := command.Run(context.Background(), os.Args)
 	code := 0
 	if err != nil {
-		fmt.Printf("command failed, error: %v", err)
+		fmt.Fprintf(os.Stderr, "command failed, error: %v\n", err)
 		code = 1
 	}
 	os.Exit(code)
 }
+
+func vaultAuthFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "approle-role-id-path",
+			Sources: cli.EnvVars("APPROLE_ROLE_ID_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "approle-secret-id-path",
+			Sources: cli.EnvVars("APPROLE_SECRET_ID_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "auth-method",
+			Sources: cli.EnvVars("AUTH_METHOD"),
+		},
+		&cli.StringFlag{
+			Name:    "auth-mount",
+			Sources: cli.EnvVars("AUTH_MOUNT"),
+		},
+		&cli.StringFlag{
+			Name:    "kubernetes-jwt-path",
+			Sources: cli.EnvVars("KUBERNETES_JWT_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "role",
+			Sources: cli.EnvVars("ROLE"),
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Sources: cli.EnvVars("TOKEN"),
+		},
+		&cli.StringFlag{
+			Name:    "token-path",
+			Sources: cli.EnvVars("TOKEN_PATH"),
+		},
+	}
+}
+
+func vaultAuthOpts(c *cli.Command) vaultpush.AuthOpts {
+	return vaultpush.AuthOpts{
+		AppRoleRoleIDPath:   c.String("approle-role-id-path"),
+		AppRoleSecretIDPath: c.String("approle-secret-id-path"),
+		KubernetesJWTPath:   c.String("kubernetes-jwt-path"),
+		Method:              vaultpush.AuthMethod(c.String("auth-method")),
+		Mount:               c.String("auth-mount"),
+		Role:                c.String("role"),
+		Token:               c.String("token"),
+		TokenPath:           c.String("token-path"),
+	}
+}
+
+type lineSliceFlag = cli.FlagBase[[]string, cli.StringConfig, lineSlice]
+
+type lineSlice struct {
+	destination *[]string
+	set         bool
+}
+
+func (l lineSlice) Create(value []string, destination *[]string, config cli.StringConfig) cli.Value {
+	*destination = append([]string{}, value...)
+	return &lineSlice{destination: destination}
+}
+
+func (l lineSlice) ToString(value []string) string {
+	return strings.Join(value, "\n")
+}
+
+func (l *lineSlice) Set(value string) error {
+	if !l.set {
+		*l.destination = []string{}
+		l.set = true
+	}
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		*l.destination = append(*l.destination, line)
+	}
+	return nil
+}
+
+func (l *lineSlice) Get() any {
+	return *l.destination
+}
+
+func (l *lineSlice) String() string {
+	if l.destination == nil {
+		return ""
+	}
+	return l.ToString(*l.destination)
+}
@@ -0,0 +1,321 @@
+package vaultpush
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+type AuthMethod string
+
+const (
+	AuthMethodAppRole    AuthMethod = "approle"
+	AuthMethodKubernetes AuthMethod = "kubernetes"
+	AuthMethodToken      AuthMethod = "token"
+)
+
+type AuthOpts struct {
+	AppRoleRoleIDPath   string
+	AppRoleSecretIDPath string
+	KubernetesJWTPath   string
+	Method              AuthMethod
+	Mount               string
+	Role                string
+	Token               string
+	TokenPath           string
+}
+
+type Auth struct {
+	AppRoleRoleIDPath   string
+	AppRoleSecretIDPath string
+	ClientToken         string
+	Expiry              time.Time
+	KubernetesJWTPath   string
+	Method              AuthMethod
+	Mount               string
+	RenewAt             time.Time
+	Renewable           bool
+	Role                string
+	Token               string
+	TokenModTime        time.Time
+	TokenPath           string
+}
+
+func NewAuth(opts *AuthOpts) (*Auth, error) {
+	method := opts.Method
+	if method == "" {
+		switch {
+		case opts.Token != "" || opts.TokenPath != "":
+			method = AuthMethodToken
+		case opts.AppRoleRoleIDPath != "" || opts.AppRoleSecretIDPath != "":
+			method = AuthMethodAppRole
+		default:
+			method = AuthMethodKubernetes
+		}
+	}
+
+	switch method {
+	case AuthMethodAppRole:
+		if opts.AppRoleRoleIDPath == "" {
+			return nil, fmt.Errorf("approle role id path unset")
+		}
+		if opts.AppRoleSecretIDPath == "" {
+			return nil, fmt.Errorf("approle secret id path unset")
+		}
+	case AuthMethodKubernetes:
+		if opts.Role == "" {
+			return nil, fmt.Errorf("role unset")
+		}
+	case AuthMethodToken:
+		if opts.Token == "" && opts.TokenPath == "" {
+			return nil, fmt.Errorf("token unset")
+		}
+	default:
+		return nil, fmt.Errorf("invalid auth method %s", method)
+	}
+
+	kubernetesJWTPath := opts.KubernetesJWTPath
+	if kubernetesJWTPath == "" {
+		kubernetesJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	}
+
+	auth := Auth{
+		AppRoleRoleIDPath:   opts.AppRoleRoleIDPath,
+		AppRoleSecretIDPath: opts.AppRoleSecretIDPath,
+		KubernetesJWTPath:   kubernetesJWTPath,
+		Method:              method,
+		Mount:               opts.Mount,
+		Role:                opts.Role,
+		Token:               opts.Token,
+		TokenPath:           opts.TokenPath,
+	}
+	return &auth, nil
+}
+
+func (a *Auth) Login(ctx context.Context, client *vault.Client) error {
+	logger := logging.FromContext(ctx)
+
+	var err error
+	switch a.Method {
+	case AuthMethodToken:
+		err = a.LoadToken(ctx, client)
+	default:
+		err = a.RefreshToken(ctx, client)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = client.SetToken(a.ClientToken)
+	if err != nil {
+		logger.Error("failed to set vault client token", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (a *Auth) Reset() {
+	a.ClientToken = ""
+	a.Expiry = time.Time{}
+	a.RenewAt = time.Time{}
+	a.Renewable = false
+	a.TokenModTime = time.Time{}
+}
+
+func (a *Auth) LoadToken(ctx context.Context, client *vault.Client) error {
+	logger := logging.FromContext(ctx)
+
+	if a.TokenPath == "" {
+		if a.ClientToken == a.Token {
+			return a.RenewStaticToken(ctx, client)
+		}
+		a.ClientToken = a.Token
+		a.LookupToken(ctx, client)
+		return nil
+	}
+
+	info, err := os.Stat(a.TokenPath)
+	if err != nil {
+		logger.Error("failed to stat token file", "path", a.TokenPath, "error", err)
+		return err
+	}
+	if a.ClientToken != "" && info.ModTime().Equal(a.TokenModTime) {
+		return a.RenewStaticToken(ctx, client)
+	}
+
+	tokenBytes, err := os.ReadFile(a.TokenPath)
+	if err != nil {
+		logger.Error("failed to read token file", "path", a.TokenPath, "error", err)
+		return err
+	}
+	token := strings.TrimSpace(string(tokenBytes))
+	if token == "" {
+		return fmt.Errorf("token file %s is empty", a.TokenPath)
+	}
+
+	logger.Info("loaded vault token from file", "path", a.TokenPath)
+	a.ClientToken = token
+	a.TokenModTime = info.ModTime()
+	a.LookupToken(ctx, client)
+	return nil
+}
+
+func (a *Auth) LookupToken(ctx context.Context, client *vault.Client) {
+	logger := logging.FromContext(ctx)
+
+	now := time.Now()
+	response, err := client.Auth.TokenLookUpSelf(ctx, vault.WithToken(a.ClientToken))
+	if err != nil {
+		logger.Warn("failed to look up vault token, token will not be renewed", "error", err)
+		a.SetLease(now, 0, false)
+		return
+	}
+
+	ttl := 0
+	switch value := response.Data["ttl"].(type) {
+	case json.Number:
+		number, _ := value.Int64()
+		ttl = int(number)
+	case float64:
+		ttl = int(value)
+	}
+	renewable, _ := response.Data["renewable"].(bool)
+	a.SetLease(now, ttl, renewable)
+	logger.Debug("looked up vault token", "expiry", a.Expiry, "renewable", a.Renewable)
+}
+
+func (a *Auth) RenewStaticToken(ctx context.Context, client *vault.Client) error {
+	logger := logging.FromContext(ctx)
+	now := time.Now()
+
+	if a.Expiry.IsZero() || now.Before(a.RenewAt) {
+		return nil
+	}
+	if !a.Renewable || !now.Before(a.Expiry) {
+		logger.Warn("vault token cannot be renewed", "expiry", a.Expiry, "renewable", a.Renewable)
+		return nil
+	}
+
+	err := a.RenewToken(ctx, client)
+	if err != nil {
+		logger.Warn("failed to renew vault token", "error", err)
+	}
+	return nil
+}
+
+func (a *Auth) RenewToken(ctx context.Context, client *vault.Client) error {
+	logger := logging.FromContext(ctx)
+
+	now := time.Now()
+	response, err := client.Auth.TokenRenewSelf(ctx, schema.TokenRenewSelfRequest{}, vault.WithToken(a.ClientToken))
+	if err != nil {
+		return err
+	}
+	if response.Auth == nil {
+		return fmt.Errorf("vault renew response missing auth")
+	}
+
+	a.SetLease(now, response.Auth.LeaseDuration, response.Auth.Renewable)
+	logger.Debug("renewed vault token", "expiry", a.Expiry)
+	return nil
+}
+
+func (a *Auth) RefreshToken(ctx context.Context, client *vault.Client) error {
+	logger := logging.FromContext(ctx)
+	now := time.Now()
+
+	if a.ClientToken != "" && (a.Expiry.IsZero() || now.Before(a.RenewAt)) {
+		logger.Debug("reusing vault token", "expiry", a.Expiry)
+		return nil
+	}
+
+	if a.ClientToken != "" && a.Renewable && now.Before(a.Expiry) {
+		err := a.RenewToken(ctx, client)
+		if err == nil {
+			return nil
+		}
+		logger.Warn("failed to renew vault token, logging in again", "error", err)
+	}
+
+	return a.LoginWithMethod(ctx, client)
+}
+
+func (a *Auth) LoginWithMethod(ctx context.Context, client *vault.Client) error {
+	logger := logging.FromContext(ctx)
+
+	options := []vault.RequestOption{}
+	if a.Mount != "" {
+		options = append(options, vault.WithMountPath(a.Mount))
+	}
+
+	var response *vault.Response[map[string]any]
+	switch a.Method {
+	case AuthMethodAppRole:
+		roleIDBytes, err := os.ReadFile(a.AppRoleRoleIDPath)
+		if err != nil {
+			logger.Error("failed to read approle role id", "path", a.AppRoleRoleIDPath, "error", err)
+			return err
+		}
+		secretIDBytes, err := os.ReadFile(a.AppRoleSecretIDPath)
+		if err != nil {
+			logger.Error("failed to read approle secret id", "path", a.AppRoleSecretIDPath, "error", err)
+			return err
+		}
+
+		response, err = client.Auth.AppRoleLogin(ctx, schema.AppRoleLoginRequest{
+			RoleId:   strings.TrimSpace(string(roleIDBytes)),
+			SecretId: strings.TrimSpace(string(secretIDBytes)),
+		}, options...)
+		if err != nil {
+			logger.Error("failed to authenticate with vault using approle", "error", err)
+			return err
+		}
+	case AuthMethodKubernetes:
+		jwtBytes, err := os.ReadFile(a.KubernetesJWTPath)
+		if err != nil {
+			logger.Error("failed to read service account token", "path", a.KubernetesJWTPath, "error", err)
+			return err
+		}
+
+		response, err = client.Auth.KubernetesLogin(ctx, schema.KubernetesLoginRequest{
+			Jwt:  strings.TrimSpace(string(jwtBytes)),
+			Role: a.Role,
+		}, options...)
+		if err != nil {
+			logger.Error("failed to authenticate with vault using kubernetes", "role", a.Role, "error", err)
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid auth method %s", a.Method)
+	}
+
+	if response.Auth == nil {
+		return fmt.Errorf("vault login response missing auth")
+	}
+
+	a.ClientToken = response.Auth.ClientToken
+	a.SetLease(time.Now(), response.Auth.LeaseDuration, response.Auth.Renewable)
+	logger.Info("authenticated with vault", "method", a.Method, "expiry", a.Expiry, "renewable", a.Renewable)
+	return nil
+}
+
+func (a *Auth) SetLease(now time.Time, leaseDuration int, renewable bool) {
+	a.Renewable = renewable
+	if leaseDuration <= 0 {
+		a.Expiry = time.Time{}
+		a.RenewAt = time.Time{}
+		return
+	}
+
+	lease := time.Duration(leaseDuration) * time.Second
+	a.Expiry = now.Add(lease)
+	a.RenewAt = now.Add(lease * 2 / 3)
+}
@@ -0,0 +1,404 @@
+package vaultpush
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+type Config struct {
+	AuthMethods map[string]*AuthMethodConfig `json:"auth_methods" yaml:"auth_methods"`
+	Mounts      map[string]*MountConfig      `json:"mounts" yaml:"mounts"`
+	Policies    map[string]string            `json:"policies" yaml:"policies"`
+}
+
+type AuthMethodConfig struct {
+	Config       map[string]any            `json:"config,omitempty" yaml:"config,omitempty"`
+	Description  string                    `json:"description,omitempty" yaml:"description,omitempty"`
+	MethodConfig map[string]any            `json:"method_config,omitempty" yaml:"method_config,omitempty"`
+	Roles        map[string]map[string]any `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Type         string                    `json:"type" yaml:"type"`
+}
+
+type MountConfig struct {
+	Config      map[string]any `json:"config,omitempty" yaml:"config,omitempty"`
+	Description string         `json:"description,omitempty" yaml:"description,omitempty"`
+	Options     map[string]any `json:"options,omitempty" yaml:"options,omitempty"`
+	Type        string         `json:"type" yaml:"type"`
+}
+
+var configAuthRoleTypes = []string{"approle", "kubernetes"}
+
+var configSkippedAuthTypes = []string{"token"}
+
+var configSkippedMountTypes = []string{"cubbyhole", "identity", "system"}
+
+var configTuneKeys = []string{
+	"allowed_managed_keys",
+	"allowed_response_headers",
+	"audit_non_hmac_request_keys",
+	"audit_non_hmac_response_keys",
+	"default_lease_ttl",
+	"listing_visibility",
+	"max_lease_ttl",
+	"passthrough_request_headers",
+}
+
+func (p *Pusher) ReadConfig(ctx context.Context) (*Config, error) {
+	logger := logging.FromContext(ctx)
+
+	policies, err := p.ExportPolicies(ctx)
+	if err != nil {
+		logger.Error("failed to export policies", "error", err)
+		return nil, err
+	}
+
+	authMethods, err := p.ExportAuthMethods(ctx)
+	if err != nil {
+		logger.Error("failed to export auth methods", "error", err)
+		return nil, err
+	}
+
+	mounts, err := p.ExportMounts(ctx)
+	if err != nil {
+		logger.Error("failed to export mounts", "error", err)
+		return nil, err
+	}
+
+	config := Config{
+		AuthMethods: authMethods,
+		Mounts:      mounts,
+		Policies:    policies,
+	}
+	return &config, nil
+}
+
+func (p *Pusher) ExportPolicies(ctx context.Context) (map[string]string, error) {
+	response, err := p.Vault.System.PoliciesListAclPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := map[string]string{}
+	for _, name := range response.Data.Keys {
+		if name == "root" {
+			continue
+		}
+
+		policyResponse, err := p.Vault.System.PoliciesReadAclPolicy(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		policies[name] = policyResponse.Data.Policy
+	}
+	return policies, nil
+}
+
+func (p *Pusher) ExportAuthMethods(ctx context.Context) (map[string]*AuthMethodConfig, error) {
+	response, err := p.Vault.System.AuthListEnabledMethods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	authMethods := map[string]*AuthMethodConfig{}
+	for path, value := range response.Data {
+		data, ok := value.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		authMethod := AuthMethodConfig{}
+		authMethod.Type, _ = data["type"].(string)
+		authMethod.Description, _ = data["description"].(string)
+		authMethod.Config, _ = data["config"].(map[string]any)
+		if slices.Contains(configSkippedAuthTypes, authMethod.Type) {
+			continue
+		}
+
+		mount := NormalizeMount(path)
+		authMethod.MethodConfig, err = p.ExportAuthMethodConfig(ctx, mount)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(configAuthRoleTypes, authMethod.Type) {
+			authMethod.Roles, err = p.ExportAuthRoles(ctx, mount, authMethod.Type)
+			if err != nil {
+				return nil, err
+			}
+		}
+		authMethods[mount] = &authMethod
+	}
+	return authMethods, nil
+}
+
+func (p *Pusher) ExportAuthMethodConfig(ctx context.Context, mount string) (map[string]any, error) {
+	response, err := p.Vault.Read(ctx, fmt.Sprintf("auth/%s/config", mount))
+	if vault.IsErrorStatus(err, http.StatusNotFound) || vault.IsErrorStatus(err, http.StatusMethodNotAllowed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data) == 0 {
+		return nil, nil
+	}
+	return response.Data, nil
+}
+
+func (p *Pusher) ExportAuthRoles(ctx context.Context, mount string, authType string) (map[string]map[string]any, error) {
+	response, err := p.Vault.List(ctx, fmt.Sprintf("auth/%s/role", mount))
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		return map[string]map[string]any{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names, _ := response.Data["keys"].([]any)
+	roles := map[string]map[string]any{}
+	for _, value := range names {
+		name, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		roleResponse, err := p.Vault.Read(ctx, fmt.Sprintf("auth/%s/role/%s", mount, name))
+		if err != nil {
+			return nil, err
+		}
+		role := roleResponse.Data
+
+		if authType == "approle" {
+			roleIDResponse, err := p.Vault.Read(ctx, fmt.Sprintf("auth/%s/role/%s/role-id", mount, name))
+			if err != nil {
+				return nil, err
+			}
+			role["role_id"] = roleIDResponse.Data["role_id"]
+		}
+
+		roles[name] = role
+	}
+	return roles, nil
+}
+
+func (p *Pusher) ExportMounts(ctx context.Context) (map[string]*MountConfig, error) {
+	response, err := p.Vault.System.MountsListSecretsEngines(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := map[string]*MountConfig{}
+	for path, value := range response.Data {
+		data, ok := value.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		mount := MountConfig{}
+		mount.Type, _ = data["type"].(string)
+		mount.Description, _ = data["description"].(string)
+		mount.Config, _ = data["config"].(map[string]any)
+		mount.Options, _ = data["options"].(map[string]any)
+		if slices.Contains(configSkippedMountTypes, mount.Type) {
+			continue
+		}
+
+		mounts[NormalizeMount(path)] = &mount
+	}
+	return mounts, nil
+}
+
+func (r *Restorer) WriteConfig(ctx context.Context, config *Config) error {
+	logger := logging.FromContext(ctx)
+
+	err := r.RestoreMounts(ctx, config.Mounts)
+	if err != nil {
+		logger.Error("failed to restore mounts", "error", err)
+		return err
+	}
+
+	err = r.RestoreAuthMethods(ctx, config.AuthMethods)
+	if err != nil {
+		logger.Error("failed to restore auth methods", "error", err)
+		return err
+	}
+
+	err = r.RestorePolicies(ctx, config.Policies)
+	if err != nil {
+		logger.Error("failed to restore policies", "error", err)
+		return err
+	}
+
+	logger.Info("config successfully restored", "mounts", len(config.Mounts), "auth-methods", len(config.AuthMethods), "policies", len(config.Policies))
+	return nil
+}
+
+func (r *Restorer) RestoreMounts(ctx context.Context, mounts map[string]*MountConfig) error {
+	logger := logging.FromContext(ctx)
+
+	response, err := r.Vault.System.MountsListSecretsEngines(ctx)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for path := range response.Data {
+		existing[NormalizeMount(path)] = true
+	}
+
+	for _, path := range sortedKeys(mounts) {
+		mount := mounts[path]
+		if !existing[path] {
+			_, err := r.Vault.Write(ctx, fmt.Sprintf("sys/mounts/%s", path), map[string]any{
+				"config":      mount.Config,
+				"description": mount.Description,
+				"options":     mount.Options,
+				"type":        mount.Type,
+			})
+			if err != nil {
+				return err
+			}
+			logger.Debug("mount enabled", "mount", path, "type", mount.Type)
+			continue
+		}
+
+		if r.Mode == RestoreModeSkip {
+			logger.Debug("mount exists, skipping", "mount", path)
+			continue
+		}
+
+		tune := map[string]any{"description": mount.Description}
+		for _, key := range configTuneKeys {
+			value, ok := mount.Config[key]
+			if ok {
+				tune[key] = value
+			}
+		}
+		_, err := r.Vault.Write(ctx, fmt.Sprintf("sys/mounts/%s/tune", path), tune)
+		if err != nil {
+			return err
+		}
+		logger.Debug("mount tuned", "mount", path)
+	}
+
+	return nil
+}
+
+func (r *Restorer) RestoreAuthMethods(ctx context.Context, authMethods map[string]*AuthMethodConfig) error {
+	logger := logging.FromContext(ctx)
+
+	response, err := r.Vault.System.AuthListEnabledMethods(ctx)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for path := range response.Data {
+		existing[NormalizeMount(path)] = true
+	}
+
+	for _, path := range sortedKeys(authMethods) {
+		authMethod := authMethods[path]
+		if !existing[path] {
+			_, err := r.Vault.Write(ctx, fmt.Sprintf("sys/auth/%s", path), map[string]any{
+				"config":      authMethod.Config,
+				"description": authMethod.Description,
+				"type":        authMethod.Type,
+			})
+			if err != nil {
+				return err
+			}
+			logger.Debug("auth method enabled", "mount", path, "type", authMethod.Type)
+		}
+
+		if len(authMethod.MethodConfig) > 0 {
+			err := r.RestoreAuthMethodConfig(ctx, path, authMethod.MethodConfig)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, name := range sortedKeys(authMethod.Roles) {
+			rolePath := fmt.Sprintf("auth/%s/role/%s", path, name)
+			if r.Mode == RestoreModeSkip {
+				_, err := r.Vault.Read(ctx, rolePath)
+				if err == nil {
+					logger.Debug("auth role exists, skipping", "mount", path, "role", name)
+					continue
+				}
+				if !vault.IsErrorStatus(err, http.StatusNotFound) {
+					return err
+				}
+			}
+
+			_, err := r.Vault.Write(ctx, rolePath, authMethod.Roles[name])
+			if err != nil {
+				return err
+			}
+			logger.Debug("auth role restored", "mount", path, "role", name)
+		}
+	}
+
+	return nil
+}
+
+func (r *Restorer) RestoreAuthMethodConfig(ctx context.Context, path string, methodConfig map[string]any) error {
+	logger := logging.FromContext(ctx)
+
+	configPath := fmt.Sprintf("auth/%s/config", path)
+	if r.Mode == RestoreModeSkip {
+		response, err := r.Vault.Read(ctx, configPath)
+		if err == nil && len(response.Data) > 0 {
+			logger.Debug("auth method config exists, skipping", "mount", path)
+			return nil
+		}
+		if err != nil && !vault.IsErrorStatus(err, http.StatusNotFound) {
+			return err
+		}
+	}
+
+	_, err := r.Vault.Write(ctx, configPath, methodConfig)
+	if err != nil {
+		return err
+	}
+	logger.Debug("auth method config restored", "mount", path)
+	return nil
+}
+
+func (r *Restorer) RestorePolicies(ctx context.Context, policies map[string]string) error {
+	logger := logging.FromContext(ctx)
+
+	for _, name := range sortedKeys(policies) {
+		if r.Mode == RestoreModeSkip {
+			_, err := r.Vault.System.PoliciesReadAclPolicy(ctx, name)
+			if err == nil {
+				logger.Debug("policy exists, skipping", "policy", name)
+				continue
+			}
+			if !vault.IsErrorStatus(err, http.StatusNotFound) {
+				return err
+			}
+		}
+
+		_, err := r.Vault.System.PoliciesWriteAclPolicy(ctx, name, schema.PoliciesWriteAclPolicyRequest{Policy: policies[name]})
+		if err != nil {
+			return err
+		}
+		logger.Debug("policy restored", "policy", name)
+	}
+
+	return nil
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
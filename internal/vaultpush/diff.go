@@ -0,0 +1,293 @@
+package vaultpush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+)
+
+var ErrDrift = errors.New("drift detected")
+
+type DiffChange string
+
+const (
+	DiffChangeAdded   DiffChange = "added"
+	DiffChangeChanged DiffChange = "changed"
+	DiffChangeRemoved DiffChange = "removed"
+)
+
+type DiffOutput string
+
+const (
+	DiffOutputJSON DiffOutput = "json"
+	DiffOutputText DiffOutput = "text"
+)
+
+type Diff struct {
+	Apps []AppDiff `json:"apps"`
+}
+
+type AppDiff struct {
+	App    string      `json:"app"`
+	Change DiffChange  `json:"change"`
+	Fields []FieldDiff `json:"fields,omitempty"`
+}
+
+type FieldDiff struct {
+	Change DiffChange `json:"change"`
+	Field  string     `json:"field"`
+}
+
+type DifferOpts struct {
+	Address                string
+	AgeIdentityPath        string
+	Auth                   AuthOpts
+	Concurrency            int
+	EncryptionKeyPath      string
+	Exclude                []string
+	Include                []string
+	Layout                 Layout
+	Output                 DiffOutput
+	SecretsPaths           []string
+	StoragePath            string
+	StorageCredentialsPath string
+	Version                string
+	Writer                 io.Writer
+}
+
+type Differ struct {
+	Output   DiffOutput
+	Pusher   *Pusher
+	Restorer *Restorer
+	Writer   io.Writer
+}
+
+func NewDiffer(opts *DifferOpts) (*Differ, error) {
+	output := opts.Output
+	if output == "" {
+		output = DiffOutputText
+	}
+	if output != DiffOutputText && output != DiffOutputJSON {
+		return nil, fmt.Errorf("invalid output %s", output)
+	}
+
+	writer := opts.Writer
+	if writer == nil {
+		writer = os.Stdout
+	}
+
+	pusher, err := New(&Opts{
+		Address:                opts.Address,
+		Auth:                   opts.Auth,
+		Concurrency:            opts.Concurrency,
+		Exclude:                opts.Exclude,
+		Include:                opts.Include,
+		SecretsPaths:           opts.SecretsPaths,
+		StoragePath:            opts.StoragePath,
+		StorageCredentialsPath: opts.StorageCredentialsPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	secretsPath := ""
+	if len(opts.SecretsPaths) == 1 {
+		secretsPath = opts.SecretsPaths[0]
+	}
+
+	restorer, err := NewRestorer(&RestorerOpts{
+		Address:                opts.Address,
+		AgeIdentityPath:        opts.AgeIdentityPath,
+		Auth:                   opts.Auth,
+		EncryptionKeyPath:      opts.EncryptionKeyPath,
+		Layout:                 opts.Layout,
+		SecretsPath:            secretsPath,
+		StoragePath:            opts.StoragePath,
+		StorageCredentialsPath: opts.StorageCredentialsPath,
+		Version:                opts.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	differ := Differ{
+		Output:   output,
+		Pusher:   pusher,
+		Restorer: restorer,
+		Writer:   writer,
+	}
+	return &differ, nil
+}
+
+func CompareDocuments(backup *Document, live *Document) (*Diff, error) {
+	apps := backup.Apps()
+	for _, app := range live.Apps() {
+		if !slices.Contains(apps, app) {
+			apps = append(apps, app)
+		}
+	}
+	sort.Strings(apps)
+
+	diff := Diff{Apps: []AppDiff{}}
+	for _, app := range apps {
+		backupSecret := lookupSecret(backup, app)
+		liveSecret := lookupSecret(live, app)
+
+		switch {
+		case backupSecret == nil:
+			diff.Apps = append(diff.Apps, AppDiff{App: app, Change: DiffChangeAdded})
+		case liveSecret == nil:
+			diff.Apps = append(diff.Apps, AppDiff{App: app, Change: DiffChangeRemoved})
+		default:
+			fields, err := compareFields(backupSecret.Data, liveSecret.Data)
+			if err != nil {
+				return nil, err
+			}
+			if len(fields) > 0 {
+				diff.Apps = append(diff.Apps, AppDiff{App: app, Change: DiffChangeChanged, Fields: fields})
+			}
+		}
+	}
+	return &diff, nil
+}
+
+func lookupSecret(document *Document, app string) *Secret {
+	mountName, path, ok := document.SplitApp(app)
+	if !ok {
+		return nil
+	}
+	return document.Mounts[mountName].Secrets[path]
+}
+
+func compareFields(backup map[string]any, live map[string]any) ([]FieldDiff, error) {
+	fields := []string{}
+	for field := range backup {
+		fields = append(fields, field)
+	}
+	for field := range live {
+		_, ok := backup[field]
+		if !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	diffs := []FieldDiff{}
+	for _, field := range fields {
+		backupValue, inBackup := backup[field]
+		liveValue, inLive := live[field]
+
+		switch {
+		case !inBackup:
+			diffs = append(diffs, FieldDiff{Change: DiffChangeAdded, Field: field})
+		case !inLive:
+			diffs = append(diffs, FieldDiff{Change: DiffChangeRemoved, Field: field})
+		default:
+			backupBytes, err := json.Marshal(backupValue)
+			if err != nil {
+				return nil, err
+			}
+			liveBytes, err := json.Marshal(liveValue)
+			if err != nil {
+				return nil, err
+			}
+			if string(backupBytes) != string(liveBytes) {
+				diffs = append(diffs, FieldDiff{Change: DiffChangeChanged, Field: field})
+			}
+		}
+	}
+	return diffs, nil
+}
+
+func (d *Differ) Print(diff *Diff) error {
+	if d.Output == DiffOutputJSON {
+		encoder := json.NewEncoder(d.Writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	}
+
+	symbols := map[DiffChange]string{
+		DiffChangeAdded:   "+",
+		DiffChangeChanged: "~",
+		DiffChangeRemoved: "-",
+	}
+	for _, app := range diff.Apps {
+		_, err := fmt.Fprintf(d.Writer, "%s %s\n", symbols[app.Change], app.App)
+		if err != nil {
+			return err
+		}
+		for _, field := range app.Fields {
+			_, err := fmt.Fprintf(d.Writer, "    %s %s\n", symbols[field.Change], field.Field)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Differ) Run(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("starting vault diff", "vault", d.Pusher.Address, "version", d.Restorer.Version)
+
+	logger.Debug("downloading secrets")
+	backup, err := d.Restorer.Download(ctx)
+	if err != nil {
+		logger.Error("failed to download secrets", "error", err)
+		return err
+	}
+	for mountName, mount := range backup.Mounts {
+		if !slices.Contains(d.Pusher.SecretsPaths, mountName) {
+			logger.Debug("mount not selected, ignoring", "mount", mountName)
+			delete(backup.Mounts, mountName)
+			continue
+		}
+		for app := range mount.Secrets {
+			if !d.Pusher.Filter.Allows(mountName + "/" + app) {
+				delete(mount.Secrets, app)
+			}
+		}
+	}
+
+	logger.Debug("authenticating with vault")
+	err = d.Pusher.AuthVault(ctx)
+	if err != nil {
+		logger.Error("vault authentication failed", "error", err)
+		return err
+	}
+	defer d.Pusher.Vault.ClearToken()
+
+	logger.Debug("exporting secrets")
+	live, err := d.Pusher.ExportSecrets(ctx)
+	if err != nil {
+		logger.Error("failed to export secrets", "error", err)
+		return err
+	}
+
+	diff, err := CompareDocuments(backup, live)
+	if err != nil {
+		logger.Error("failed to compare secrets", "error", err)
+		return err
+	}
+
+	err = d.Print(diff)
+	if err != nil {
+		logger.Error("failed to print diff", "error", err)
+		return err
+	}
+
+	if len(diff.Apps) > 0 {
+		logger.Info("drift detected", "apps", len(diff.Apps))
+		return ErrDrift
+	}
+
+	logger.Info("no drift detected")
+	return nil
+}
@@ -0,0 +1,67 @@
+package vaultpush
+
+import (
+	"sort"
+	"strings"
+)
+
+type Document struct {
+	Config *Config           `json:"config,omitempty" yaml:"config,omitempty"`
+	Mounts map[string]*Mount `json:"mounts" yaml:"mounts"`
+}
+
+type Mount struct {
+	Secrets map[string]*Secret `json:"secrets" yaml:"secrets"`
+	Version int                `json:"version" yaml:"version"`
+}
+
+type Secret struct {
+	Data     map[string]any  `json:"data" yaml:"data"`
+	Metadata *SecretMetadata `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Versions []SecretVersion `json:"versions,omitempty" yaml:"versions,omitempty"`
+}
+
+type SecretMetadata struct {
+	CasRequired        bool           `json:"cas_required" yaml:"cas_required"`
+	CurrentVersion     int64          `json:"current_version" yaml:"current_version"`
+	CustomMetadata     map[string]any `json:"custom_metadata,omitempty" yaml:"custom_metadata,omitempty"`
+	DeleteVersionAfter string         `json:"delete_version_after,omitempty" yaml:"delete_version_after,omitempty"`
+	MaxVersions        int64          `json:"max_versions" yaml:"max_versions"`
+}
+
+type SecretVersion struct {
+	CreatedTime string         `json:"created_time,omitempty" yaml:"created_time,omitempty"`
+	Data        map[string]any `json:"data,omitempty" yaml:"data,omitempty"`
+	Deleted     bool           `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+	Destroyed   bool           `json:"destroyed,omitempty" yaml:"destroyed,omitempty"`
+	Version     int64          `json:"version" yaml:"version"`
+}
+
+func NormalizeMount(mount string) string {
+	return strings.Trim(mount, "/")
+}
+
+func (d *Document) Apps() []string {
+	apps := []string{}
+	for mountName, mount := range d.Mounts {
+		for app := range mount.Secrets {
+			apps = append(apps, mountName+"/"+app)
+		}
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+func (d *Document) SplitApp(app string) (string, string, bool) {
+	for mountName, mount := range d.Mounts {
+		path, ok := strings.CutPrefix(app, mountName+"/")
+		if !ok {
+			continue
+		}
+		_, ok = mount.Secrets[path]
+		if ok {
+			return mountName, path, true
+		}
+	}
+	return "", "", false
+}
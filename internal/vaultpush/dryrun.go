@@ -0,0 +1,121 @@
+package vaultpush
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+	"github.com/hashicorp/vault-client-go"
+)
+
+func (p *Pusher) EncodeData(document *Document, encode func(*Document) ([]byte, error)) ([]byte, error) {
+	dataBytes, err := encode(document)
+	if err != nil {
+		return nil, err
+	}
+	if p.Cipher != nil {
+		return p.Cipher.Encrypt(dataBytes)
+	}
+	return dataBytes, nil
+}
+
+func (p *Pusher) PushDryRun(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+
+	logger.Debug("authenticating with vault")
+	err := p.AuthVault(ctx)
+	if err != nil {
+		logger.Error("vault authentication failed", "error", err)
+		return err
+	}
+
+	logger.Debug("checking storage write permissions")
+	err = p.Storage.CheckWrite(ctx, p.StorageKey)
+	if err != nil {
+		logger.Error("storage is not writable", "storage-path", p.StoragePath, "error", err)
+		return err
+	}
+
+	if p.Snapshot {
+		logger.Debug("reading raft snapshot")
+		snapshot, err := p.ReadSnapshot(ctx)
+		if err != nil {
+			logger.Error("failed to read raft snapshot", "error", err)
+			return err
+		}
+		defer snapshot.Close()
+
+		changed, err := p.Changed(ctx, snapshot.Checksum)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("dry run: would upload snapshot", "storage-path", p.StoragePath, "bytes", snapshot.UploadSize, "checksum", snapshot.Checksum, "changed", changed)
+		return nil
+	}
+
+	logger.Debug("exporting secrets")
+	secrets, err := p.ExportSecrets(ctx)
+	if vault.IsErrorStatus(err, http.StatusForbidden) {
+		logger.Warn("vault denied access, discarding cached token")
+		p.Auth.Reset()
+	}
+	if err != nil {
+		logger.Error("failed to export secrets", "error", err)
+		return err
+	}
+
+	apps := secrets.Apps()
+	for _, app := range apps {
+		appDocument, _ := secrets.AppDocument(app)
+		checksum, err := p.Checksum(ctx, appDocument)
+		if err != nil {
+			return err
+		}
+		dataBytes, err := p.EncodeData(appDocument, p.EncodeApp)
+		if err != nil {
+			logger.Error("failed to encode secrets", "app", app, "format", p.Format, "error", err)
+			return err
+		}
+
+		fields := []any{"app", app, "bytes", len(dataBytes), "checksum", checksum}
+		if p.Layout == LayoutPerApp {
+			fields = append(fields, "key", AppKey(p.StorageKey, app, p.Format))
+		}
+		if p.Mirror != nil {
+			mountName, secretPath, _ := secrets.SplitApp(app)
+			namespace, name, ok, err := p.Mirror.Target(mountName, secretPath)
+			if err != nil {
+				logger.Error("failed to map app to kubernetes secret", "app", app, "error", err)
+				return err
+			}
+			if ok {
+				fields = append(fields, "kubernetes-secret", namespace+"/"+name)
+			}
+		}
+		logger.Info("dry run: would upload app", fields...)
+	}
+
+	if p.Layout == LayoutPerApp {
+		logger.Info("dry run complete", "storage-path", p.StoragePath, "apps", len(apps))
+		return nil
+	}
+
+	checksum, err := p.Checksum(ctx, secrets)
+	if err != nil {
+		logger.Error("failed to calculate checksum", "error", err)
+		return err
+	}
+	dataBytes, err := p.EncodeData(secrets, p.Encode)
+	if err != nil {
+		logger.Error("failed to encode secrets", "format", p.Format, "error", err)
+		return err
+	}
+	changed, err := p.Changed(ctx, checksum)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("dry run: would upload backup", "storage-path", p.StoragePath, "apps", len(apps), "bytes", len(dataBytes), "checksum", checksum, "changed", changed)
+	return nil
+}
@@ -0,0 +1,339 @@
+package vaultpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+type Cipher interface {
+	Decrypt(data []byte) ([]byte, error)
+	Encrypt(data []byte) ([]byte, error)
+	EncryptStream(writer io.Writer) (io.WriteCloser, error)
+}
+
+type CipherOpts struct {
+	AgeIdentityPath string
+	AgeRecipients   []string
+	KeyPath         string
+}
+
+func NewCipher(opts *CipherOpts) (Cipher, error) {
+	useAge := opts.AgeIdentityPath != "" || len(opts.AgeRecipients) > 0
+	useKey := opts.KeyPath != ""
+
+	if useAge && useKey {
+		return nil, fmt.Errorf("age and encryption key are mutually exclusive")
+	}
+
+	if useKey {
+		aesCipher, err := NewAESCipher(opts.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		return aesCipher, nil
+	}
+
+	if useAge {
+		ageCipher, err := NewAgeCipher(opts.AgeRecipients, opts.AgeIdentityPath)
+		if err != nil {
+			return nil, err
+		}
+		return ageCipher, nil
+	}
+
+	return nil, nil
+}
+
+var aesHeader = []byte("homelab-helper/aes-256-gcm/v1\n")
+
+var checksumKeyLabel = []byte("homelab-helper/checksum/v1")
+
+type AESCipher struct {
+	AEAD        cipher.AEAD
+	ChecksumKey []byte
+}
+
+func NewAESCipher(keyPath string) (*AESCipher, error) {
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key := keyBytes
+	if len(key) != 32 {
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyBytes)))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key must be 32 raw bytes or base64-encoded: %w", err)
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(checksumKeyLabel)
+
+	aesCipher := AESCipher{
+		AEAD:        aead,
+		ChecksumKey: mac.Sum(nil),
+	}
+	return &aesCipher, nil
+}
+
+func (c *AESCipher) Encrypt(data []byte) ([]byte, error) {
+	nonce := make([]byte, c.AEAD.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := append([]byte{}, aesHeader...)
+	encrypted = append(encrypted, nonce...)
+	encrypted = c.AEAD.Seal(encrypted, nonce, data, aesHeader)
+	return encrypted, nil
+}
+
+var aesStreamHeader = []byte("homelab-helper/aes-256-gcm-stream/v1\n")
+
+const (
+	aesStreamPrefixSize  = 7
+	aesStreamSegmentSize = 64 * 1024
+)
+
+type aesStreamWriter struct {
+	Buffer  []byte
+	Cipher  *AESCipher
+	Counter uint32
+	Prefix  []byte
+	Writer  io.Writer
+}
+
+func (c *AESCipher) EncryptStream(writer io.Writer) (io.WriteCloser, error) {
+	prefix := make([]byte, aesStreamPrefixSize)
+	_, err := rand.Read(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = writer.Write(aesStreamHeader)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	streamWriter := aesStreamWriter{
+		Buffer: make([]byte, 0, aesStreamSegmentSize+c.AEAD.Overhead()),
+		Cipher: c,
+		Prefix: prefix,
+		Writer: writer,
+	}
+	return &streamWriter, nil
+}
+
+func aesStreamNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 0, aesStreamPrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+func (w *aesStreamWriter) Seal(final bool) error {
+	if w.Counter == math.MaxUint32 {
+		return fmt.Errorf("encrypted stream too large")
+	}
+
+	nonce := aesStreamNonce(w.Prefix, w.Counter, final)
+	encrypted := w.Cipher.AEAD.Seal(w.Buffer[:0], nonce, w.Buffer, aesStreamHeader)
+	w.Buffer = w.Buffer[:0]
+	w.Counter++
+
+	_, err := w.Writer.Write(encrypted)
+	return err
+}
+
+func (w *aesStreamWriter) Write(data []byte) (int, error) {
+	written := len(data)
+	for len(data) > 0 {
+		if len(w.Buffer) == aesStreamSegmentSize {
+			err := w.Seal(false)
+			if err != nil {
+				return 0, err
+			}
+		}
+		size := min(aesStreamSegmentSize-len(w.Buffer), len(data))
+		w.Buffer = append(w.Buffer, data[:size]...)
+		data = data[size:]
+	}
+	return written, nil
+}
+
+func (w *aesStreamWriter) Close() error {
+	return w.Seal(true)
+}
+
+func (c *AESCipher) DecryptStream(data []byte) ([]byte, error) {
+	data = data[len(aesStreamHeader):]
+	if len(data) < aesStreamPrefixSize {
+		return nil, fmt.Errorf("encrypted data truncated")
+	}
+	prefix := data[:aesStreamPrefixSize]
+	data = data[aesStreamPrefixSize:]
+
+	segmentSize := aesStreamSegmentSize + c.AEAD.Overhead()
+	decrypted := []byte{}
+	for counter := uint32(0); ; counter++ {
+		final := len(data) <= segmentSize
+		segment := data
+		if !final {
+			segment = data[:segmentSize]
+		}
+
+		var err error
+		decrypted, err = c.AEAD.Open(decrypted, aesStreamNonce(prefix, counter, final), segment, aesStreamHeader)
+		if err != nil {
+			return nil, err
+		}
+		if final {
+			return decrypted, nil
+		}
+		data = data[segmentSize:]
+	}
+}
+
+func (c *AESCipher) Decrypt(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, aesStreamHeader) {
+		return c.DecryptStream(data)
+	}
+	if !bytes.HasPrefix(data, aesHeader) {
+		return nil, fmt.Errorf("data is not aes-256-gcm encrypted")
+	}
+	data = data[len(aesHeader):]
+
+	nonceSize := c.AEAD.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("encrypted data truncated")
+	}
+	nonce := data[:nonceSize]
+	data = data[nonceSize:]
+
+	decrypted, err := c.AEAD.Open(nil, nonce, data, aesHeader)
+	if err != nil {
+		return nil, err
+	}
+	return decrypted, nil
+}
+
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+type AgeCipher struct {
+	Identities []age.Identity
+	Recipients []age.Recipient
+}
+
+func NewAgeCipher(recipientStrings []string, identityPath string) (*AgeCipher, error) {
+	recipients := []age.Recipient{}
+	for _, recipientString := range recipientStrings {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(recipientString))
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	identities := []age.Identity{}
+	if identityPath != "" {
+		identityFile, err := os.Open(identityPath)
+		if err != nil {
+			return nil, err
+		}
+		defer identityFile.Close()
+
+		identities, err = age.ParseIdentities(identityFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ageCipher := AgeCipher{
+		Identities: identities,
+		Recipients: recipients,
+	}
+	return &ageCipher, nil
+}
+
+func (c *AgeCipher) Encrypt(data []byte) ([]byte, error) {
+	if len(c.Recipients) == 0 {
+		return nil, fmt.Errorf("age recipients unset")
+	}
+
+	buffer := bytes.Buffer{}
+	writer, err := age.Encrypt(&buffer, c.Recipients...)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (c *AgeCipher) EncryptStream(writer io.Writer) (io.WriteCloser, error) {
+	if len(c.Recipients) == 0 {
+		return nil, fmt.Errorf("age recipients unset")
+	}
+	return age.Encrypt(writer, c.Recipients...)
+}
+
+func (c *AgeCipher) Decrypt(data []byte) ([]byte, error) {
+	if len(c.Identities) == 0 {
+		return nil, fmt.Errorf("age identities unset")
+	}
+
+	reader, err := age.Decrypt(bytes.NewReader(data), c.Identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, aesHeader) || bytes.HasPrefix(data, aesStreamHeader) || bytes.HasPrefix(data, ageHeader)
+}
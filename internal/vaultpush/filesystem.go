@@ -0,0 +1,253 @@
+package vaultpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+type FileStorage struct {
+	Root string
+}
+
+func NewFileStorage(ctx context.Context, location *StorageLocation, credentialsPath string) (*FileStorage, error) {
+	fileStorage := FileStorage{
+		Root: "/",
+	}
+	return &fileStorage, nil
+}
+
+func (s *FileStorage) Path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(key))
+}
+
+func (s *FileStorage) MetadataPath(key string) string {
+	path := s.Path(key)
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".metadata")
+}
+
+func (s *FileStorage) CheckWrite(ctx context.Context, key string) error {
+	directory := filepath.Dir(s.Path(key))
+	for {
+		info, err := os.Stat(directory)
+		if errors.Is(err, fs.ErrNotExist) {
+			directory = filepath.Dir(directory)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", directory)
+		}
+		break
+	}
+
+	err := unix.Access(directory, unix.W_OK)
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", directory, err)
+	}
+	return nil
+}
+
+func (s *FileStorage) Delete(ctx context.Context, key string) error {
+	lockFile, err := s.Lock(key)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+
+	err = os.Remove(s.Path(key))
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(s.MetadataPath(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	directory := filepath.Dir(s.Path(prefix + "_"))
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *FileStorage) Read(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(s.Path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+type FileMetadata struct {
+	Generation int64             `json:"generation"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+func (s *FileStorage) ReadMetadata(key string) (*FileMetadata, error) {
+	_, err := os.Stat(s.Path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fileMetadata := FileMetadata{Metadata: map[string]string{}}
+	metadataBytes, err := os.ReadFile(s.MetadataPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &fileMetadata, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(metadataBytes, &fileMetadata)
+	if err != nil || fileMetadata.Generation == 0 {
+		fileMetadata = FileMetadata{Metadata: map[string]string{}}
+		err = json.Unmarshal(metadataBytes, &fileMetadata.Metadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fileMetadata, nil
+}
+
+func (s *FileStorage) Lock(key string) (*os.File, error) {
+	directory := filepath.Dir(s.Path(key))
+	err := os.MkdirAll(directory, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	lockFile, err := os.OpenFile(filepath.Join(directory, ".lock"), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.Flock(int(lockFile.Fd()), unix.LOCK_EX)
+	if err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	return lockFile, nil
+}
+
+func (s *FileStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	fileMetadata, err := s.ReadMetadata(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info := ObjectInfo{
+		Generation: strconv.FormatInt(fileMetadata.Generation, 10),
+		Metadata:   fileMetadata.Metadata,
+	}
+	return &info, nil
+}
+
+func (s *FileStorage) Write(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string, generation string) (string, error) {
+	lockFile, err := s.Lock(key)
+	if err != nil {
+		return "", err
+	}
+	defer lockFile.Close()
+
+	current := ""
+	next := int64(1)
+	fileMetadata, err := s.ReadMetadata(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+	if err == nil {
+		current = strconv.FormatInt(fileMetadata.Generation, 10)
+		next = fileMetadata.Generation + 1
+	}
+	if generation != GenerationAny && current != generation {
+		return "", ErrPreconditionFailed
+	}
+
+	err = s.WriteFile(s.Path(key), reader)
+	if err != nil {
+		return "", err
+	}
+
+	metadataBytes, err := json.Marshal(FileMetadata{Generation: next, Metadata: metadata})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.WriteFile(s.MetadataPath(key), bytes.NewReader(metadataBytes))
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(next, 10), nil
+}
+
+func (s *FileStorage) WriteFile(path string, reader io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, reader)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
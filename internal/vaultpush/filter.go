@@ -0,0 +1,112 @@
+package vaultpush
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type Filter struct {
+	Exclude []*regexp.Regexp
+	Include []*regexp.Regexp
+}
+
+func NewFilter(include []string, exclude []string) (*Filter, error) {
+	includeRules, err := ParseFilterRules(include)
+	if err != nil {
+		return nil, err
+	}
+
+	excludeRules, err := ParseFilterRules(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := Filter{
+		Exclude: excludeRules,
+		Include: includeRules,
+	}
+	return &filter, nil
+}
+
+func ParseFilterRules(rules []string) ([]*regexp.Regexp, error) {
+	expressions := []*regexp.Regexp{}
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		pattern, ok := strings.CutPrefix(rule, "re:")
+		if !ok {
+			pattern = GlobPattern(rule)
+		}
+
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter rule %s: %w", rule, err)
+		}
+		expressions = append(expressions, expression)
+	}
+	return expressions, nil
+}
+
+func GlobPattern(glob string) string {
+	pattern := strings.Builder{}
+	pattern.WriteString("^")
+	for index := 0; index < len(glob); index++ {
+		switch glob[index] {
+		case '*':
+			if index+1 < len(glob) && glob[index+1] == '*' {
+				pattern.WriteString(".*")
+				index++
+			} else {
+				pattern.WriteString("[^/]*")
+			}
+		case '?':
+			pattern.WriteString("[^/]")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(glob[index])))
+		}
+	}
+	pattern.WriteString("$")
+	return pattern.String()
+}
+
+func (f *Filter) Allows(app string) bool {
+	if len(f.Include) > 0 && !matchesAny(f.Include, app) {
+		return false
+	}
+	return !matchesAny(f.Exclude, app)
+}
+
+func (f *Filter) Apply(mount string, apps []string) []string {
+	filtered := []string{}
+	for _, app := range apps {
+		if f.Allows(mount + "/" + app) {
+			filtered = append(filtered, app)
+		}
+	}
+	return filtered
+}
+
+func (f *Filter) Strings() ([]string, []string) {
+	include := []string{}
+	for _, expression := range f.Include {
+		include = append(include, expression.String())
+	}
+	exclude := []string{}
+	for _, expression := range f.Exclude {
+		exclude = append(exclude, expression.String())
+	}
+	return include, exclude
+}
+
+func matchesAny(expressions []*regexp.Regexp, value string) bool {
+	for _, expression := range expressions {
+		if expression.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
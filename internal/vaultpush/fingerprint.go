@@ -0,0 +1,114 @@
+package vaultpush
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+	"github.com/hashicorp/vault-client-go"
+	"golang.org/x/sync/errgroup"
+)
+
+type ChangeDetection string
+
+const (
+	ChangeDetectionChecksum ChangeDetection = "checksum"
+	ChangeDetectionMetadata ChangeDetection = "metadata"
+)
+
+func (p *Pusher) ReadFingerprint(ctx context.Context, mount string, version int, path string) (string, error) {
+	if version == 1 {
+		response, err := p.Vault.Secrets.KvV1Read(ctx, path, vault.WithMountPath(mount))
+		if err != nil {
+			return "", err
+		}
+		dataBytes, err := json.Marshal(response.Data)
+		if err != nil {
+			return "", err
+		}
+		return p.Hash(dataBytes), nil
+	}
+
+	response, err := p.Vault.Secrets.KvV2ReadMetadata(ctx, path, vault.WithMountPath(mount))
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	metadata := response.Data
+	versionInfo, _ := metadata.Versions[strconv.FormatInt(metadata.CurrentVersion, 10)].(map[string]any)
+	deletionTime, _ := versionInfo["deletion_time"].(string)
+	return fmt.Sprintf("%d:%s:%s", metadata.CurrentVersion, metadata.UpdatedTime, deletionTime), nil
+}
+
+func (p *Pusher) Fingerprint(ctx context.Context) (string, error) {
+	logger := logging.FromContext(ctx)
+
+	mountVersions := map[string]int{}
+	mountApps := map[string][]string{}
+	for _, mountName := range p.SecretsPaths {
+		version, err := p.MountVersion(ctx, mountName)
+		if err != nil {
+			return "", err
+		}
+
+		apps, err := p.ListSecrets(ctx, mountName, version, "")
+		if err != nil {
+			return "", err
+		}
+
+		mountVersions[mountName] = version
+		mountApps[mountName] = p.Filter.Apply(mountName, apps)
+	}
+
+	entries := []string{}
+	lock := sync.Mutex{}
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(p.Concurrency)
+	for mountName, version := range mountVersions {
+		for _, app := range mountApps[mountName] {
+			group.Go(func() error {
+				fingerprint, err := p.ReadFingerprint(groupCtx, mountName, version, app)
+				if err != nil {
+					logger.Error("failed to read secret metadata", "mount", mountName, "app", app, "error", err)
+					return err
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				entries = append(entries, fmt.Sprintf("%s/%s=%s", mountName, app, fingerprint))
+				return nil
+			})
+		}
+	}
+
+	err := group.Wait()
+	if err != nil {
+		return "", err
+	}
+
+	if p.ExportConfig {
+		config, err := p.ReadConfig(ctx)
+		if err != nil {
+			return "", err
+		}
+		configBytes, err := json.Marshal(config)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, "config="+p.Hash(configBytes))
+	}
+
+	sort.Strings(entries)
+	entriesBytes, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+	return p.Hash(entriesBytes), nil
+}
@@ -0,0 +1,185 @@
+package vaultpush
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+type Format string
+
+const (
+	FormatDotenv Format = "dotenv"
+	FormatJSON   Format = "json"
+	FormatSOPS   Format = "sops"
+	FormatYAML   Format = "yaml"
+)
+
+var Formats = []Format{FormatDotenv, FormatJSON, FormatSOPS, FormatYAML}
+
+func (f Format) Extension() string {
+	switch f {
+	case FormatDotenv:
+		return ".env"
+	case FormatJSON:
+		return ".json"
+	default:
+		return ".yaml"
+	}
+}
+
+var ErrLegacyDocument = errors.New("backup uses the legacy app to data format")
+
+var ErrExportOnly = errors.New("dotenv backups are export-only and cannot be restored or diffed")
+
+func EncodeDocument(format Format, document *Document) ([]byte, error) {
+	switch format {
+	case FormatDotenv:
+		return EncodeDotenv(document)
+	case FormatJSON:
+		return json.MarshalIndent(document, "", "  ")
+	case FormatYAML:
+		return yaml.Marshal(document)
+	default:
+		return nil, fmt.Errorf("format %s cannot be encoded without encryption", format)
+	}
+}
+
+func DecodeDocument(format Format, data []byte) (*Document, error) {
+	document := Document{}
+	switch format {
+	case FormatDotenv:
+		return nil, ErrExportOnly
+	case FormatJSON:
+		err := json.Unmarshal(data, &document)
+		if err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		err := yaml.Unmarshal(data, &document)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("format %s cannot be decoded", format)
+	}
+
+	if document.Mounts == nil {
+		legacy, err := decodeLegacy(format, data)
+		if err == nil && len(legacy) > 0 {
+			return nil, ErrLegacyDocument
+		}
+		return nil, fmt.Errorf("backup contains no mounts")
+	}
+	return &document, nil
+}
+
+func DecodeLegacyDocument(format Format, data []byte, mount string) (*Document, error) {
+	legacy, err := decodeLegacy(format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := map[string]*Secret{}
+	for app, data := range legacy {
+		secrets[app] = &Secret{Data: data}
+	}
+	document := Document{
+		Mounts: map[string]*Mount{
+			NormalizeMount(mount): {Secrets: secrets, Version: 2},
+		},
+	}
+	return &document, nil
+}
+
+func decodeLegacy(format Format, data []byte) (map[string]map[string]any, error) {
+	legacy := map[string]map[string]any{}
+	switch format {
+	case FormatJSON:
+		err := json.Unmarshal(data, &legacy)
+		if err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		err := yaml.Unmarshal(data, &legacy)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("format %s cannot be decoded", format)
+	}
+	return legacy, nil
+}
+
+func SecretString(value any) (string, error) {
+	valueString, ok := value.(string)
+	if ok {
+		return valueString, nil
+	}
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(valueBytes), nil
+}
+
+func EncodeDotenv(document *Document) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	writer := tar.NewWriter(&buffer)
+
+	for _, app := range document.Apps() {
+		mountName, path, _ := document.SplitApp(app)
+		content, err := EncodeDotenvSecret(document.Mounts[mountName].Secrets[path])
+		if err != nil {
+			return nil, err
+		}
+
+		header := tar.Header{
+			ModTime:  time.Unix(0, 0),
+			Mode:     0o600,
+			Name:     app + ".env",
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		err = writer.WriteHeader(&header)
+		if err != nil {
+			return nil, err
+		}
+		_, err = writer.Write(content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func EncodeDotenvSecret(secret *Secret) ([]byte, error) {
+	keys := []string{}
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := strings.Builder{}
+	for _, key := range keys {
+		value, err := SecretString(secret.Data[key])
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&lines, "%s=%s\n", key, strconv.Quote(value))
+	}
+	return []byte(lines.String()), nil
+}
@@ -0,0 +1,141 @@
+package vaultpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type GCSStorage struct {
+	Bucket string
+	Client *storage.Client
+}
+
+func NewGCSStorage(ctx context.Context, location *StorageLocation, credentialsPath string) (*GCSStorage, error) {
+	options := []option.ClientOption{}
+	if credentialsPath != "" {
+		options = append(options, option.WithCredentialsFile(credentialsPath))
+	}
+
+	client, err := storage.NewClient(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	gcsStorage := GCSStorage{
+		Bucket: location.Bucket,
+		Client: client,
+	}
+	return &gcsStorage, nil
+}
+
+func (s *GCSStorage) CheckWrite(ctx context.Context, key string) error {
+	required := []string{"storage.objects.create", "storage.objects.delete"}
+	granted, err := s.Client.Bucket(s.Bucket).IAM().TestPermissions(ctx, required)
+	if err != nil {
+		return err
+	}
+
+	for _, permission := range required {
+		if !slices.Contains(granted, permission) {
+			return fmt.Errorf("missing permission %s on bucket %s", permission, s.Bucket)
+		}
+	}
+	return nil
+}
+
+func (s *GCSStorage) Delete(ctx context.Context, key string) error {
+	return s.Client.Bucket(s.Bucket).Object(key).Delete(ctx)
+}
+
+func (s *GCSStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	objects := s.Client.Bucket(s.Bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, attrs.Name)
+	}
+	return keys, nil
+}
+
+func (s *GCSStorage) Read(ctx context.Context, key string) (io.ReadCloser, error) {
+	reader, err := s.Client.Bucket(s.Bucket).Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (s *GCSStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	attrs, err := s.Client.Bucket(s.Bucket).Object(key).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	for key, value := range attrs.Metadata {
+		metadata[key] = value
+	}
+
+	info := ObjectInfo{
+		Generation: strconv.FormatInt(attrs.Generation, 10),
+		Metadata:   metadata,
+	}
+	return &info, nil
+}
+
+func (s *GCSStorage) Write(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string, generation string) (string, error) {
+	object := s.Client.Bucket(s.Bucket).Object(key)
+	switch generation {
+	case GenerationAny:
+	case "":
+		object = object.If(storage.Conditions{DoesNotExist: true})
+	default:
+		generationNumber, err := strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid generation %s", generation)
+		}
+		object = object.If(storage.Conditions{GenerationMatch: generationNumber})
+	}
+
+	writer := object.NewWriter(ctx)
+	writer.Metadata = metadata
+
+	_, err := io.Copy(writer, reader)
+	if err != nil {
+		writer.Close()
+		return "", err
+	}
+
+	err = writer.Close()
+	var apiError *googleapi.Error
+	if errors.As(err, &apiError) && apiError.Code == http.StatusPreconditionFailed {
+		return "", ErrPreconditionFailed
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(writer.Attrs().Generation, 10), nil
+}
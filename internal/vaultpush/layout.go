@@ -0,0 +1,255 @@
+package vaultpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+)
+
+type Layout string
+
+const (
+	LayoutPerApp Layout = "per-app"
+	LayoutSingle Layout = "single"
+)
+
+func AppPrefix(key string) string {
+	return key + "/"
+}
+
+func AppKey(key string, app string, format Format) string {
+	return AppPrefix(key) + app + format.Extension()
+}
+
+func AppFromKey(key string, objectKey string) (string, bool) {
+	app, ok := strings.CutPrefix(objectKey, AppPrefix(key))
+	if !ok {
+		return "", false
+	}
+	for _, format := range Formats {
+		trimmed, ok := strings.CutSuffix(app, format.Extension())
+		if ok {
+			return trimmed, true
+		}
+	}
+	return "", false
+}
+
+func (p *Pusher) EncodeApp(document *Document) ([]byte, error) {
+	if p.Format != FormatDotenv {
+		return p.Encode(document)
+	}
+	for _, app := range document.Apps() {
+		mountName, path, _ := document.SplitApp(app)
+		return EncodeDotenvSecret(document.Mounts[mountName].Secrets[path])
+	}
+	return nil, fmt.Errorf("document contains no apps")
+}
+
+func (d *Document) AppDocument(app string) (*Document, bool) {
+	mountName, path, ok := d.SplitApp(app)
+	if !ok {
+		return nil, false
+	}
+	mount := d.Mounts[mountName]
+	document := Document{
+		Mounts: map[string]*Mount{
+			mountName: {
+				Secrets: map[string]*Secret{path: mount.Secrets[path]},
+				Version: mount.Version,
+			},
+		},
+	}
+	return &document, true
+}
+
+func (d *Document) Merge(other *Document) {
+	if d.Mounts == nil {
+		d.Mounts = map[string]*Mount{}
+	}
+	for mountName, mount := range other.Mounts {
+		existing, ok := d.Mounts[mountName]
+		if !ok {
+			existing = &Mount{Secrets: map[string]*Secret{}, Version: mount.Version}
+			d.Mounts[mountName] = existing
+		}
+		for path, secret := range mount.Secrets {
+			existing.Secrets[path] = secret
+		}
+	}
+}
+
+func (p *Pusher) RemoteAppChecksums(ctx context.Context) (map[string]string, map[string]string, error) {
+	keys, err := p.Storage.List(ctx, AppPrefix(p.StorageKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	checksums := map[string]string{}
+	generations := map[string]string{}
+	for _, key := range keys {
+		_, ok := AppFromKey(p.StorageKey, key)
+		if !ok {
+			continue
+		}
+		info, err := p.Storage.Stat(ctx, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		checksums[key] = info.Metadata["checksum"]
+		generations[key] = info.Generation
+	}
+	return checksums, generations, nil
+}
+
+func (p *Pusher) PushApps(ctx context.Context, document *Document) error {
+	logger := logging.FromContext(ctx)
+
+	if p.AppChecksums == nil {
+		logger.Debug("fetching remote app checksums")
+		checksums, generations, err := p.RemoteAppChecksums(ctx)
+		if err != nil {
+			logger.Error("failed to fetch remote app checksums", "storage-path", p.StoragePath, "error", err)
+			return err
+		}
+		p.AppChecksums = checksums
+		p.AppGenerations = generations
+	}
+
+	current := map[string]bool{}
+	uploaded := 0
+	for _, app := range document.Apps() {
+		key := AppKey(p.StorageKey, app, p.Format)
+		current[key] = true
+
+		appDocument, _ := document.AppDocument(app)
+		checksum, err := p.Checksum(ctx, appDocument)
+		if err != nil {
+			return err
+		}
+		if p.AppChecksums[key] == checksum {
+			continue
+		}
+
+		dataBytes, err := p.EncodeApp(appDocument)
+		if err != nil {
+			logger.Error("failed to encode secrets", "app", app, "format", p.Format, "error", err)
+			return err
+		}
+		if p.Cipher != nil {
+			dataBytes, err = p.Cipher.Encrypt(dataBytes)
+			if err != nil {
+				logger.Error("failed to encrypt secrets", "app", app, "error", err)
+				return err
+			}
+		}
+
+		metadata := map[string]string{"format": string(p.Format)}
+		if p.StoresChecksums() {
+			metadata["checksum"] = checksum
+		}
+		generation, err := p.Storage.Write(ctx, key, bytes.NewReader(dataBytes), int64(len(dataBytes)), metadata, p.AppGenerations[key])
+		if errors.Is(err, ErrPreconditionFailed) {
+			logger.Error("app was modified concurrently, discarding cached remote state", "app", app)
+			p.ResetRemoteState()
+			return err
+		}
+		if err != nil {
+			logger.Error("failed to upload app to storage", "app", app, "error", err)
+			return err
+		}
+		p.Metrics.BytesUploaded.Add(float64(len(dataBytes)))
+
+		hash := sha256.Sum256(dataBytes)
+		err = p.Verify(ctx, key, hash[:])
+		if err != nil {
+			logger.Error("failed to verify uploaded app", "app", app, "error", err)
+			p.ResetRemoteState()
+			return err
+		}
+		p.AppChecksums[key] = checksum
+		p.AppGenerations[key] = generation
+		logger.Debug("uploaded app", "app", app, "checksum", checksum)
+		uploaded++
+	}
+
+	stale := []string{}
+	for key := range p.AppChecksums {
+		if !current[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		err := p.Storage.Delete(ctx, key)
+		if err != nil {
+			logger.Error("failed to delete app from storage", "key", key, "error", err)
+			return err
+		}
+		delete(p.AppChecksums, key)
+		delete(p.AppGenerations, key)
+		logger.Debug("deleted app", "key", key)
+	}
+
+	if uploaded == 0 && len(stale) == 0 {
+		logger.Info("secrets unchanged, skipping upload")
+		p.Metrics.Skips.Inc()
+		return nil
+	}
+
+	p.Metrics.Pushes.Inc()
+	logger.Info("secrets successfully pushed", "uploaded", uploaded, "deleted", len(stale), "unchanged", len(current)-uploaded)
+	p.Notify(ctx, NotificationEventChanged, fmt.Sprintf("secrets pushed to %s (%d uploaded, %d deleted)", p.StoragePath, uploaded, len(stale)), nil)
+	return nil
+}
+
+func (r *Restorer) DownloadApps(ctx context.Context) (*Document, error) {
+	logger := logging.FromContext(ctx)
+
+	keys, err := r.Storage.List(ctx, AppPrefix(r.StorageKey))
+	if err != nil {
+		logger.Error("failed to list apps in storage", "storage-path", r.StoragePath, "error", err)
+		return nil, err
+	}
+
+	apps := map[string]string{}
+	for _, key := range keys {
+		app, ok := AppFromKey(r.StorageKey, key)
+		if ok {
+			apps[app] = key
+		}
+	}
+
+	document := Document{Mounts: map[string]*Mount{}}
+	for app, key := range apps {
+		if len(r.Apps) > 0 && r.SecretsPath == "" && !matchesSelectors(r.Apps, app) {
+			continue
+		}
+
+		appDocument, err := r.DownloadObject(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		document.Merge(appDocument)
+	}
+
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("no apps found at %s", r.StoragePath)
+	}
+	return &document, nil
+}
+
+func matchesSelectors(selectors []string, app string) bool {
+	for _, selector := range selectors {
+		if app == selector || (strings.HasSuffix(selector, "/") && strings.HasPrefix(app, selector)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package vaultpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Metrics struct {
+	BytesUploaded   prometheus.Counter
+	Failures        prometheus.Counter
+	LastAttemptTime atomic.Int64
+	LastSuccess     prometheus.Gauge
+	LastSuccessTime atomic.Int64
+	MirrorFailures  prometheus.Counter
+	Pushes          prometheus.Counter
+	Registry        *prometheus.Registry
+	Skips           prometheus.Counter
+	StartTime       time.Time
+}
+
+func NewMetrics() *Metrics {
+	metrics := Metrics{
+		BytesUploaded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vault_push_uploaded_bytes_total",
+			Help: "Total number of bytes uploaded to storage.",
+		}),
+		Failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vault_push_failures_total",
+			Help: "Total number of failed pushes.",
+		}),
+		LastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "vault_push_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful push.",
+		}),
+		MirrorFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vault_push_mirror_failures_total",
+			Help: "Total number of failed kubernetes secret mirrors.",
+		}),
+		Pushes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vault_push_pushes_total",
+			Help: "Total number of pushes that uploaded a backup.",
+		}),
+		Registry: prometheus.NewRegistry(),
+		Skips: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vault_push_skips_total",
+			Help: "Total number of pushes skipped because the backup was unchanged.",
+		}),
+		StartTime: time.Now(),
+	}
+
+	metrics.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metrics.BytesUploaded,
+		metrics.Failures,
+		metrics.LastSuccess,
+		metrics.MirrorFailures,
+		metrics.Pushes,
+		metrics.Skips,
+	)
+	return &metrics
+}
+
+func (m *Metrics) RecordFailure(now time.Time) {
+	m.Failures.Inc()
+	m.LastAttemptTime.Store(now.UnixNano())
+}
+
+func (m *Metrics) RecordSuccess(now time.Time) {
+	m.LastAttemptTime.Store(now.UnixNano())
+	m.LastSuccessTime.Store(now.UnixNano())
+	m.LastSuccess.Set(float64(now.Unix()))
+}
+
+func (m *Metrics) Healthy(now time.Time, threshold time.Duration) bool {
+	last := m.StartTime
+	lastAttempt := m.LastAttemptTime.Load()
+	if lastAttempt != 0 {
+		last = time.Unix(0, lastAttempt)
+	}
+	return now.Sub(last) <= threshold
+}
+
+func (m *Metrics) Ready(now time.Time, threshold time.Duration) bool {
+	lastSuccess := m.LastSuccessTime.Load()
+	if lastSuccess == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, lastSuccess)) <= threshold
+}
+
+func (p *Pusher) HealthThreshold() time.Duration {
+	return time.Duration(p.ReadyIntervals)*p.Period(time.Now()) + p.Jitter
+}
+
+func (p *Pusher) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !p.Metrics.Healthy(time.Now(), p.HealthThreshold()) {
+			http.Error(w, "no push attempt completed recently", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !p.Metrics.Ready(time.Now(), p.HealthThreshold()) {
+			http.Error(w, "no push succeeded recently", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(p.Metrics.Registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+func (p *Pusher) StartServer(ctx context.Context) func() {
+	logger := logging.FromContext(ctx)
+
+	server := &http.Server{
+		Addr:              p.HealthAddress,
+		Handler:           p.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		logger.Info("starting health server", "address", p.HealthAddress)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("health server failed", "error", err)
+		}
+	}()
+
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			logger.Warn("failed to shut down health server", "error", err)
+		}
+	}
+}
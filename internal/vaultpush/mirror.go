@@ -0,0 +1,296 @@
+package vaultpush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	MirrorAnnotationApp      = "vault-push-secrets.homelab-helper.benfiola.com/app"
+	MirrorAnnotationChecksum = "vault-push-secrets.homelab-helper.benfiola.com/checksum"
+	MirrorLabelInstance      = "vault-push-secrets.homelab-helper.benfiola.com/instance"
+	MirrorLabelManagedBy     = "app.kubernetes.io/managed-by"
+	MirrorManagedBy          = "vault-push-secrets"
+)
+
+var mirrorInvalidName = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+type MirrorRule struct {
+	Expression *regexp.Regexp
+	Name       string
+	Namespace  string
+}
+
+type MirrorOpts struct {
+	Instance string
+	Rules    []string
+}
+
+type Mirror struct {
+	Client   client.Client
+	Instance string
+	Rules    []MirrorRule
+}
+
+func NewMirror(opts *MirrorOpts) (*Mirror, error) {
+	instance := opts.Instance
+	if instance == "" {
+		instance = MirrorManagedBy
+	}
+	if errs := validation.IsValidLabelValue(instance); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid mirror instance %s: %s", instance, strings.Join(errs, ", "))
+	}
+
+	rules, err := ParseMirrorRules(opts.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	kubeClient, err := client.New(config, client.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	mirror := Mirror{
+		Client:   kubeClient,
+		Instance: instance,
+		Rules:    rules,
+	}
+	return &mirror, nil
+}
+
+func ParseMirrorRules(rules []string) ([]MirrorRule, error) {
+	mirrorRules := []MirrorRule{}
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		index := strings.LastIndex(rule, "=")
+		if index == -1 {
+			return nil, fmt.Errorf("invalid mirror rule %s: expected <pattern>=<namespace>/<name>", rule)
+		}
+		namespace, name, ok := strings.Cut(rule[index+1:], "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("invalid mirror rule %s: expected <pattern>=<namespace>/<name>", rule)
+		}
+
+		expressions, err := ParseFilterRules([]string{rule[:index]})
+		if err != nil {
+			return nil, err
+		}
+		if len(expressions) == 0 {
+			return nil, fmt.Errorf("invalid mirror rule %s: pattern unset", rule)
+		}
+
+		mirrorRules = append(mirrorRules, MirrorRule{
+			Expression: expressions[0],
+			Name:       name,
+			Namespace:  namespace,
+		})
+	}
+	if len(mirrorRules) == 0 {
+		return nil, fmt.Errorf("mirror rules unset")
+	}
+	return mirrorRules, nil
+}
+
+func (m *Mirror) Target(mountName string, secretPath string) (string, string, bool, error) {
+	app := mountName + "/" + secretPath
+	for _, rule := range m.Rules {
+		if !rule.Expression.MatchString(app) {
+			continue
+		}
+
+		replacer := strings.NewReplacer(
+			"{mount}", mountName,
+			"{name}", path.Base(secretPath),
+			"{path}", secretPath,
+		)
+		namespace := MirrorName(replacer.Replace(rule.Namespace))
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return "", "", false, fmt.Errorf("invalid namespace %s for %s: %s", namespace, app, strings.Join(errs, ", "))
+		}
+		name := MirrorName(replacer.Replace(rule.Name))
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return "", "", false, fmt.Errorf("invalid name %s for %s: %s", name, app, strings.Join(errs, ", "))
+		}
+		return namespace, name, true, nil
+	}
+	return "", "", false, nil
+}
+
+func MirrorName(value string) string {
+	value = strings.ToLower(value)
+	value = mirrorInvalidName.ReplaceAllString(value, "-")
+	return strings.Trim(value, "-.")
+}
+
+func MirrorDataEqual(current map[string][]byte, desired map[string][]byte) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+	for key, value := range desired {
+		currentValue, ok := current[key]
+		if !ok || !bytes.Equal(currentValue, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Mirror) Labels() map[string]string {
+	return map[string]string{
+		MirrorLabelInstance:  m.Instance,
+		MirrorLabelManagedBy: MirrorManagedBy,
+	}
+}
+
+func (p *Pusher) MirrorAndRecord(ctx context.Context, document *Document) {
+	logger := logging.FromContext(ctx)
+
+	logger.Debug("mirroring secrets to kubernetes")
+	err := p.MirrorSecrets(ctx, document)
+	if err != nil {
+		logger.Error("failed to mirror secrets to kubernetes", "error", err)
+		p.Metrics.MirrorFailures.Inc()
+		p.Notify(ctx, NotificationEventMirrorFailed, fmt.Sprintf("mirror of %s to kubernetes failed: %s", p.StoragePath, err), err)
+	}
+}
+
+func (p *Pusher) MirrorSecrets(ctx context.Context, document *Document) error {
+	logger := logging.FromContext(ctx)
+
+	existingList := corev1.SecretList{}
+	err := p.Mirror.Client.List(ctx, &existingList, client.MatchingLabels(p.Mirror.Labels()))
+	if err != nil {
+		logger.Error("failed to list mirrored kubernetes secrets", "error", err)
+		return err
+	}
+	existing := map[string]*corev1.Secret{}
+	for index := range existingList.Items {
+		secret := &existingList.Items[index]
+		existing[secret.Namespace+"/"+secret.Name] = secret
+	}
+
+	desired := map[string]string{}
+	created := 0
+	updated := 0
+	for _, app := range document.Apps() {
+		mountName, secretPath, _ := document.SplitApp(app)
+		namespace, name, ok, err := p.Mirror.Target(mountName, secretPath)
+		if err != nil {
+			logger.Error("failed to map app to kubernetes secret", "app", app, "error", err)
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		target := namespace + "/" + name
+		other, ok := desired[target]
+		if ok {
+			return fmt.Errorf("apps %s and %s both map to kubernetes secret %s", other, app, target)
+		}
+		desired[target] = app
+
+		appDocument, _ := document.AppDocument(app)
+		checksum, err := p.Checksum(ctx, appDocument)
+		if err != nil {
+			return err
+		}
+
+		data := map[string][]byte{}
+		for key, value := range document.Mounts[mountName].Secrets[secretPath].Data {
+			valueString, err := SecretString(value)
+			if err != nil {
+				return err
+			}
+			data[key] = []byte(valueString)
+		}
+
+		current, ok := existing[target]
+		if ok && current.Annotations[MirrorAnnotationChecksum] == checksum && current.Type == corev1.SecretTypeOpaque && MirrorDataEqual(current.Data, data) {
+			continue
+		}
+
+		annotations := map[string]string{
+			MirrorAnnotationApp:      app,
+			MirrorAnnotationChecksum: checksum,
+		}
+
+		if ok {
+			current.Annotations = annotations
+			current.Data = data
+			current.Type = corev1.SecretTypeOpaque
+			err = p.Mirror.Client.Update(ctx, current)
+			if err != nil {
+				logger.Error("failed to update kubernetes secret", "app", app, "secret", target, "error", err)
+				return err
+			}
+			logger.Debug("updated kubernetes secret", "app", app, "secret", target)
+			updated++
+			continue
+		}
+
+		secret := corev1.Secret{
+			Data: data,
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: annotations,
+				Labels:      p.Mirror.Labels(),
+				Name:        name,
+				Namespace:   namespace,
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+		err = p.Mirror.Client.Create(ctx, &secret)
+		if apierrors.IsAlreadyExists(err) {
+			err = fmt.Errorf("kubernetes secret %s exists and is not managed by %s", target, p.Mirror.Instance)
+		}
+		if err != nil {
+			logger.Error("failed to create kubernetes secret", "app", app, "secret", target, "error", err)
+			return err
+		}
+		logger.Debug("created kubernetes secret", "app", app, "secret", target)
+		created++
+	}
+
+	stale := []string{}
+	for target := range existing {
+		_, ok := desired[target]
+		if !ok {
+			stale = append(stale, target)
+		}
+	}
+	sort.Strings(stale)
+	for _, target := range stale {
+		err := p.Mirror.Client.Delete(ctx, existing[target])
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error("failed to delete kubernetes secret", "secret", target, "error", err)
+			return err
+		}
+		logger.Debug("deleted kubernetes secret", "secret", target)
+	}
+
+	logger.Info("mirrored secrets to kubernetes", "created", created, "updated", updated, "deleted", len(stale), "unchanged", len(desired)-created-updated)
+	return nil
+}
@@ -0,0 +1,213 @@
+package vaultpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+)
+
+type NotificationEvent string
+
+const (
+	NotificationEventChanged      NotificationEvent = "changed"
+	NotificationEventFailed       NotificationEvent = "failed"
+	NotificationEventMirrorFailed NotificationEvent = "mirror-failed"
+)
+
+type NotifierKind string
+
+const (
+	NotifierKindGotify  NotifierKind = "gotify"
+	NotifierKindNtfy    NotifierKind = "ntfy"
+	NotifierKindSlack   NotifierKind = "slack"
+	NotifierKindWebhook NotifierKind = "webhook"
+)
+
+type Notification struct {
+	Error       string            `json:"error,omitempty"`
+	Event       NotificationEvent `json:"event"`
+	Message     string            `json:"message"`
+	StoragePath string            `json:"storage_path"`
+	Suppressed  int               `json:"suppressed"`
+	Time        time.Time         `json:"time"`
+	Title       string            `json:"title"`
+	Vault       string            `json:"vault"`
+}
+
+type Notifier struct {
+	Kind NotifierKind
+	URL  string
+}
+
+type Notifications struct {
+	Client     *http.Client
+	Interval   time.Duration
+	LastSent   map[NotificationEvent]time.Time
+	Notifiers  []Notifier
+	Suppressed map[NotificationEvent]int
+}
+
+func NewNotifications(sinks []string, interval time.Duration) (*Notifications, error) {
+	notifiers := []Notifier{}
+	for _, sink := range sinks {
+		sink = strings.TrimSpace(sink)
+		if sink == "" {
+			continue
+		}
+
+		kind, rawURL, ok := strings.Cut(sink, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid notification sink %s: expected <kind>=<url>", sink)
+		}
+		notifierKind := NotifierKind(kind)
+		switch notifierKind {
+		case NotifierKindGotify, NotifierKindNtfy, NotifierKindSlack, NotifierKindWebhook:
+		default:
+			return nil, fmt.Errorf("invalid notification sink %s: unsupported kind %s", sink, kind)
+		}
+		parsed, err := url.Parse(rawURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("invalid notification sink %s: invalid url", sink)
+		}
+
+		notifiers = append(notifiers, Notifier{Kind: notifierKind, URL: rawURL})
+	}
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+
+	if interval == 0 {
+		interval = 15 * time.Minute
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid notify interval %s", interval)
+	}
+
+	notifications := Notifications{
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		Interval:   interval,
+		LastSent:   map[NotificationEvent]time.Time{},
+		Notifiers:  notifiers,
+		Suppressed: map[NotificationEvent]int{},
+	}
+	return &notifications, nil
+}
+
+func (n *Notifications) Send(ctx context.Context, notification Notification) {
+	logger := logging.FromContext(ctx)
+
+	lastSent, ok := n.LastSent[notification.Event]
+	if ok && notification.Time.Sub(lastSent) < n.Interval {
+		n.Suppressed[notification.Event]++
+		logger.Debug("notification rate limited", "event", notification.Event, "suppressed", n.Suppressed[notification.Event])
+		return
+	}
+	notification.Suppressed = n.Suppressed[notification.Event]
+	n.LastSent[notification.Event] = notification.Time
+	n.Suppressed[notification.Event] = 0
+
+	for _, notifier := range n.Notifiers {
+		err := n.Post(ctx, notifier, notification)
+		if err != nil {
+			logger.Warn("failed to send notification", "kind", notifier.Kind, "event", notification.Event, "error", err)
+		}
+	}
+}
+
+func (n *Notifications) Post(ctx context.Context, notifier Notifier, notification Notification) error {
+	message := notification.Message
+	if notification.Suppressed > 0 {
+		message = fmt.Sprintf("%s (%d similar notifications suppressed)", message, notification.Suppressed)
+	}
+
+	contentType := "application/json"
+	headers := map[string]string{}
+	var body any
+	switch notifier.Kind {
+	case NotifierKindGotify:
+		priority := 4
+		if notification.Event != NotificationEventChanged {
+			priority = 8
+		}
+		body = map[string]any{"message": message, "priority": priority, "title": notification.Title}
+	case NotifierKindNtfy:
+		contentType = "text/plain"
+		headers["Priority"] = "default"
+		headers["Tags"] = "floppy_disk"
+		headers["Title"] = notification.Title
+		if notification.Event != NotificationEventChanged {
+			headers["Priority"] = "high"
+			headers["Tags"] = "warning"
+		}
+		body = message
+	case NotifierKindSlack:
+		body = map[string]any{"text": fmt.Sprintf("*%s*\n%s", notification.Title, message)}
+	default:
+		body = notification
+	}
+
+	var payload []byte
+	switch body := body.(type) {
+	case string:
+		payload = []byte(body)
+	default:
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, notifier.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := n.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (p *Pusher) Notify(ctx context.Context, event NotificationEvent, message string, err error) {
+	if p.Notifications == nil {
+		return
+	}
+
+	title := "vault backup updated"
+	switch event {
+	case NotificationEventFailed:
+		title = "vault backup failed"
+	case NotificationEventMirrorFailed:
+		title = "vault kubernetes mirror failed"
+	}
+	notification := Notification{
+		Event:       event,
+		Message:     message,
+		StoragePath: p.StoragePath,
+		Time:        time.Now(),
+		Title:       title,
+		Vault:       p.Address,
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	p.Notifications.Send(ctx, notification)
+}
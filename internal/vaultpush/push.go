@@ -3,46 +3,111 @@ package vaultpush
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"cloud.google.com/go/storage"
+	"filippo.io/age"
 	"github.com/benfiola/homelab-helper/internal/logging"
-	"github.com/goccy/go-yaml"
 	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
-	"google.golang.org/api/option"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/sync/errgroup"
 )
 
 type Opts struct {
 	Address                string
+	AgeRecipients          []string
+	Auth                   AuthOpts
+	ChangeDetection        ChangeDetection
+	Concurrency            int
+	DryRun                 bool
+	EncryptionKeyPath      string
+	Exclude                []string
+	ExportConfig           bool
+	ExportMetadata         bool
+	ExportVersions         int
+	FailFast               bool
+	Format                 Format
+	HealthAddress          string
+	Include                []string
 	Interval               time.Duration
-	Role                   string
+	Jitter                 time.Duration
+	Layout                 Layout
+	MirrorInstance         string
+	MirrorRules            []string
+	Notify                 []string
+	NotifyInterval         time.Duration
+	ReadyIntervals         int
+	Retention              Retention
+	Retry                  Retry
 	RunForever             *bool
-	SecretsPath            string
+	Schedule               string
+	SecretsPaths           []string
+	Snapshot               bool
 	StoragePath            string
 	StorageCredentialsPath string
-	Token                  string
+	Timezone               string
+	VerifyUploads          *bool
+	Versioned              bool
 }
 
 type Pusher struct {
-	Address      string
-	Interval     time.Duration
-	LastChecksum string
-	Role         string
-	RunForever   bool
-	SecretsPath  string
-	Storage      *storage.Client
-	StoragePath  string
-	Token        string
-	Vault        *vault.Client
+	Address         string
+	AppChecksums    map[string]string
+	AppGenerations  map[string]string
+	Auth            *Auth
+	ChangeDetection ChangeDetection
+	Cipher          Cipher
+	Concurrency     int
+	DryRun          bool
+	ExportConfig    bool
+	ExportMetadata  bool
+	ExportVersions  int
+	FailFast        bool
+	Filter          *Filter
+	Format          Format
+	GenerationKnown bool
+	HealthAddress   string
+	Interval        time.Duration
+	Jitter          time.Duration
+	LastChecksum    string
+	LastDocument    *Document
+	LastFingerprint string
+	LastGeneration  string
+	Layout          Layout
+	Location        *time.Location
+	Metrics         *Metrics
+	Mirror          *Mirror
+	Notifications   *Notifications
+	ReadyIntervals  int
+	Retention       Retention
+	Retry           Retry
+	RunForever      bool
+	Schedule        cron.Schedule
+	SecretsPaths    []string
+	Snapshot        bool
+	SOPSRecipients  []age.Recipient
+	Storage         Storage
+	StorageKey      string
+	StoragePath     string
+	Vault           *vault.Client
+	VerifyUploads   bool
+	Versioned       bool
 }
 
 func New(opts *Opts) (*Pusher, error) {
@@ -50,13 +115,123 @@ func New(opts *Opts) (*Pusher, error) {
 		return nil, fmt.Errorf("address unset")
 	}
 
+	changeDetection := opts.ChangeDetection
+	if changeDetection == "" {
+		changeDetection = ChangeDetectionChecksum
+	}
+	if changeDetection != ChangeDetectionChecksum && changeDetection != ChangeDetectionMetadata {
+		return nil, fmt.Errorf("invalid change detection %s", changeDetection)
+	}
+	if changeDetection == ChangeDetectionMetadata && opts.Snapshot {
+		return nil, fmt.Errorf("metadata change detection cannot be combined with snapshot")
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = 4
+	}
+	if concurrency < 0 {
+		return nil, fmt.Errorf("invalid concurrency %d", concurrency)
+	}
+
+	schedule, location, err := ParseSchedule(opts.Schedule, opts.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	if schedule != nil && opts.Interval != 0 {
+		return nil, fmt.Errorf("interval cannot be combined with schedule")
+	}
+
 	interval := opts.Interval
 	if interval == 0 {
 		interval = 10 * time.Minute
 	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid interval %s", interval)
+	}
+
+	if opts.Jitter < 0 {
+		return nil, fmt.Errorf("invalid jitter %s", opts.Jitter)
+	}
+
+	readyIntervals := opts.ReadyIntervals
+	if readyIntervals == 0 {
+		readyIntervals = 3
+	}
+	if readyIntervals < 0 {
+		return nil, fmt.Errorf("invalid ready intervals %d", readyIntervals)
+	}
 
-	if opts.Role == "" {
-		return nil, fmt.Errorf("role unset")
+	cipher, err := NewCipher(&CipherOpts{
+		AgeRecipients: opts.AgeRecipients,
+		KeyPath:       opts.EncryptionKeyPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = FormatYAML
+	}
+	if !slices.Contains(Formats, format) {
+		return nil, fmt.Errorf("invalid format %s", format)
+	}
+	if format != FormatYAML && opts.Snapshot {
+		return nil, fmt.Errorf("format cannot be combined with snapshot")
+	}
+
+	filter, err := NewFilter(opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	if (len(filter.Include) > 0 || len(filter.Exclude) > 0) && opts.Snapshot {
+		return nil, fmt.Errorf("filters cannot be combined with snapshot")
+	}
+
+	layout := opts.Layout
+	if layout == "" {
+		layout = LayoutSingle
+	}
+	if layout != LayoutSingle && layout != LayoutPerApp {
+		return nil, fmt.Errorf("invalid layout %s", layout)
+	}
+	if layout == LayoutPerApp && (opts.Snapshot || opts.Versioned || opts.ExportConfig) {
+		return nil, fmt.Errorf("per-app layout cannot be combined with snapshot, versioned or export config")
+	}
+
+	sopsRecipients := []age.Recipient{}
+	if format == FormatSOPS {
+		ageCipher, ok := cipher.(*AgeCipher)
+		if !ok {
+			return nil, fmt.Errorf("sops format requires age recipients")
+		}
+		sopsRecipients = ageCipher.Recipients
+		cipher = nil
+	}
+
+	if opts.ExportVersions < 0 {
+		return nil, fmt.Errorf("invalid export versions %d", opts.ExportVersions)
+	}
+
+	if opts.Retention.Daily < 0 || opts.Retention.Last < 0 {
+		return nil, fmt.Errorf("invalid retention")
+	}
+
+	retry := opts.Retry
+	if retry.InitialDelay == 0 {
+		retry.InitialDelay = 10 * time.Second
+	}
+	if retry.MaxDelay == 0 {
+		retry.MaxDelay = interval
+	}
+	if retry.InitialDelay < 0 || retry.MaxDelay < retry.InitialDelay || retry.MaxFailures < 0 {
+		return nil, fmt.Errorf("invalid retry")
+	}
+
+	auth, err := NewAuth(&opts.Auth)
+	if err != nil {
+		return nil, err
 	}
 
 	runForever := true
@@ -64,20 +239,54 @@ func New(opts *Opts) (*Pusher, error) {
 		runForever = *opts.RunForever
 	}
 
-	if opts.SecretsPath == "" {
-		return nil, fmt.Errorf("secrets path unset")
+	verifyUploads := true
+	if opts.VerifyUploads != nil {
+		verifyUploads = *opts.VerifyUploads
 	}
 
-	if opts.StorageCredentialsPath == "" {
-		return nil, fmt.Errorf("storage credentials path unset")
+	secretsPaths := []string{}
+	for _, secretsPath := range opts.SecretsPaths {
+		secretsPath = NormalizeMount(secretsPath)
+		if secretsPath == "" {
+			continue
+		}
+		secretsPaths = append(secretsPaths, secretsPath)
+	}
+	if len(secretsPaths) == 0 && !opts.Snapshot {
+		return nil, fmt.Errorf("secrets paths unset")
+	}
+	if len(secretsPaths) > 0 && opts.Snapshot {
+		return nil, fmt.Errorf("secrets paths cannot be combined with snapshot")
+	}
+	if opts.Snapshot && (opts.ExportConfig || opts.ExportMetadata || opts.ExportVersions > 0) {
+		return nil, fmt.Errorf("export options cannot be combined with snapshot")
 	}
 
-	storageClient, err := storage.NewClient(context.Background(), option.WithCredentialsFile(opts.StorageCredentialsPath))
+	notifications, err := NewNotifications(opts.Notify, opts.NotifyInterval)
 	if err != nil {
 		return nil, err
 	}
 
-	_, _, err = ParseStoragePath(opts.StoragePath)
+	var mirror *Mirror
+	if len(opts.MirrorRules) > 0 {
+		if opts.Snapshot {
+			return nil, fmt.Errorf("mirror rules cannot be combined with snapshot")
+		}
+		mirror, err = NewMirror(&MirrorOpts{
+			Instance: opts.MirrorInstance,
+			Rules:    opts.MirrorRules,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	storageLocation, err := ParseStoragePath(opts.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := NewStorage(context.Background(), storageLocation, opts.StorageCredentialsPath)
 	if err != nil {
 		return nil, err
 	}
@@ -90,131 +299,454 @@ func New(opts *Opts) (*Pusher, error) {
 	}
 
 	pusher := Pusher{
-		Address:     opts.Address,
-		Interval:    interval,
-		Role:        opts.Role,
-		RunForever:  runForever,
-		SecretsPath: opts.SecretsPath,
-		Storage:     storageClient,
-		StoragePath: opts.StoragePath,
-		Token:       opts.Token,
-		Vault:       vaultClient,
+		Address:         opts.Address,
+		Auth:            auth,
+		ChangeDetection: changeDetection,
+		Cipher:          cipher,
+		Concurrency:     concurrency,
+		DryRun:          opts.DryRun,
+		ExportConfig:    opts.ExportConfig,
+		ExportMetadata:  opts.ExportMetadata,
+		ExportVersions:  opts.ExportVersions,
+		FailFast:        opts.FailFast,
+		Filter:          filter,
+		Format:          format,
+		HealthAddress:   opts.HealthAddress,
+		Interval:        interval,
+		Jitter:          opts.Jitter,
+		Layout:          layout,
+		Location:        location,
+		Metrics:         NewMetrics(),
+		Mirror:          mirror,
+		Notifications:   notifications,
+		ReadyIntervals:  readyIntervals,
+		Retention:       opts.Retention,
+		Retry:           retry,
+		RunForever:      runForever,
+		Schedule:        schedule,
+		SecretsPaths:    secretsPaths,
+		SOPSRecipients:  sopsRecipients,
+		Snapshot:        opts.Snapshot,
+		Storage:         storage,
+		StorageKey:      storageLocation.Key,
+		StoragePath:     opts.StoragePath,
+		Vault:           vaultClient,
+		VerifyUploads:   verifyUploads,
+		Versioned:       opts.Versioned,
 	}
 	return &pusher, nil
 }
 
-var storagePathRegex = regexp.MustCompile("^gs://([^/]+)/(.*[^/])$")
+func (p *Pusher) MountVersion(ctx context.Context, mount string) (int, error) {
+	logger := logging.FromContext(ctx)
+
+	response, err := p.Vault.System.MountsReadConfiguration(ctx, mount)
+	if err != nil {
+		logger.Error("failed to read mount configuration", "mount", mount, "error", err)
+		return 0, err
+	}
 
-func ParseStoragePath(storagePath string) (string, string, error) {
-	matches := storagePathRegex.FindStringSubmatch(storagePath)
-	if matches == nil {
-		return "", "", fmt.Errorf("invalid storage path %s", storagePath)
+	if response.Data.Type != "kv" && response.Data.Type != "generic" {
+		return 0, fmt.Errorf("mount %s has unsupported type %s", mount, response.Data.Type)
+	}
+
+	version := 1
+	versionString, ok := response.Data.Options["version"].(string)
+	if ok && versionString != "" {
+		version, err = strconv.Atoi(versionString)
+		if err != nil {
+			return 0, fmt.Errorf("mount %s has invalid version %s", mount, versionString)
+		}
 	}
-	bucket := matches[1]
-	path := matches[2]
-	return bucket, path, nil
+
+	return version, nil
+}
+
+func (p *Pusher) ListSecrets(ctx context.Context, mount string, version int, prefix string) ([]string, error) {
+	logger := logging.FromContext(ctx)
+
+	var response *vault.Response[schema.StandardListResponse]
+	var err error
+	if version == 1 {
+		response, err = p.Vault.Secrets.KvV1List(ctx, prefix, vault.WithMountPath(mount))
+	} else {
+		response, err = p.Vault.Secrets.KvV2List(ctx, prefix, vault.WithMountPath(mount))
+	}
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		logger.Error("failed to list secrets from vault", "mount", mount, "prefix", prefix, "error", err)
+		return nil, err
+	}
+
+	secrets := []string{}
+	for _, key := range response.Data.Keys {
+		path := prefix + key
+		if !strings.HasSuffix(key, "/") {
+			secrets = append(secrets, path)
+			continue
+		}
+
+		children, err := p.ListSecrets(ctx, mount, version, path)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, children...)
+	}
+
+	return secrets, nil
+}
+
+func (p *Pusher) ReadSecret(ctx context.Context, mount string, version int, path string) (*Secret, error) {
+	if version == 1 {
+		response, err := p.Vault.Secrets.KvV1Read(ctx, path, vault.WithMountPath(mount))
+		if err != nil {
+			return nil, err
+		}
+		return &Secret{Data: response.Data}, nil
+	}
+
+	response, err := p.Vault.Secrets.KvV2Read(ctx, path, vault.WithMountPath(mount))
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret := Secret{Data: response.Data.Data}
+
+	if !p.ExportMetadata && p.ExportVersions == 0 {
+		return &secret, nil
+	}
+
+	metadataResponse, err := p.Vault.Secrets.KvV2ReadMetadata(ctx, path, vault.WithMountPath(mount))
+	if err != nil {
+		return nil, err
+	}
+	metadata := metadataResponse.Data
+	secret.Metadata = &SecretMetadata{
+		CasRequired:        metadata.CasRequired,
+		CurrentVersion:     metadata.CurrentVersion,
+		CustomMetadata:     metadata.CustomMetadata,
+		DeleteVersionAfter: metadata.DeleteVersionAfter,
+		MaxVersions:        metadata.MaxVersions,
+	}
+
+	oldest := max(metadata.OldestVersion, metadata.CurrentVersion-int64(p.ExportVersions), 1)
+	for versionNumber := oldest; versionNumber < metadata.CurrentVersion; versionNumber++ {
+		secretVersion, err := p.ReadSecretVersion(ctx, mount, path, versionNumber, metadata.Versions)
+		if err != nil {
+			return nil, err
+		}
+		secret.Versions = append(secret.Versions, *secretVersion)
+	}
+
+	return &secret, nil
+}
+
+func (p *Pusher) ReadSecretVersion(ctx context.Context, mount string, path string, versionNumber int64, versions map[string]any) (*SecretVersion, error) {
+	secretVersion := SecretVersion{Version: versionNumber}
+
+	versionInfo, _ := versions[strconv.FormatInt(versionNumber, 10)].(map[string]any)
+	secretVersion.CreatedTime, _ = versionInfo["created_time"].(string)
+	deletionTime, _ := versionInfo["deletion_time"].(string)
+	secretVersion.Deleted = deletionTime != ""
+	secretVersion.Destroyed, _ = versionInfo["destroyed"].(bool)
+	if secretVersion.Deleted || secretVersion.Destroyed {
+		return &secretVersion, nil
+	}
+
+	query := url.Values{"version": {strconv.FormatInt(versionNumber, 10)}}
+	response, err := p.Vault.Secrets.KvV2Read(ctx, path, vault.WithMountPath(mount), vault.WithQueryParameters(query))
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		secretVersion.Deleted = true
+		return &secretVersion, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secretVersion.Data = response.Data.Data
+
+	return &secretVersion, nil
 }
 
-func (p *Pusher) ExportSecrets(ctx context.Context) (map[string]any, error) {
+func (p *Pusher) ExportSecrets(ctx context.Context) (*Document, error) {
 	logger := logging.FromContext(ctx)
 
-	response, err := p.Vault.Secrets.KvV2List(ctx, "", vault.WithMountPath(p.SecretsPath))
+	document := Document{Mounts: map[string]*Mount{}}
+	mountApps := map[string][]string{}
+	for _, mountName := range p.SecretsPaths {
+		version, err := p.MountVersion(ctx, mountName)
+		if err != nil {
+			return nil, err
+		}
+
+		apps, err := p.ListSecrets(ctx, mountName, version, "")
+		if err != nil {
+			return nil, err
+		}
+		apps = p.Filter.Apply(mountName, apps)
+
+		document.Mounts[mountName] = &Mount{Secrets: map[string]*Secret{}, Version: version}
+		mountApps[mountName] = apps
+	}
+
+	lock := sync.Mutex{}
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(p.Concurrency)
+	for mountName, mount := range document.Mounts {
+		for _, app := range mountApps[mountName] {
+			group.Go(func() error {
+				secret, err := p.ReadSecret(groupCtx, mountName, mount.Version, app)
+				if err != nil {
+					logger.Error("failed to read secret", "mount", mountName, "app", app, "error", err)
+					return err
+				}
+				if secret == nil {
+					logger.Debug("secret deleted, skipping", "mount", mountName, "app", app)
+					return nil
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				mount.Secrets[app] = secret
+				return nil
+			})
+		}
+	}
+
+	err := group.Wait()
 	if err != nil {
-		logger.Error("failed to list secrets from vault", "secrets-path", p.SecretsPath, "error", err)
 		return nil, err
 	}
-	apps := response.Data.Keys
 
-	data := map[string]any{}
-	for _, app := range apps {
-		response, err := p.Vault.Secrets.KvV2Read(ctx, app, vault.WithMountPath(p.SecretsPath))
+	if p.ExportConfig {
+		document.Config, err = p.ReadConfig(ctx)
 		if err != nil {
-			logger.Error("failed to read secret", "app", app, "error", err)
 			return nil, err
 		}
-		data[app] = response.Data.Data
 	}
 
-	return data, nil
+	return &document, nil
 }
 
-func (p *Pusher) Checksum(ctx context.Context, data map[string]any) (string, error) {
+func (p *Pusher) Checksum(ctx context.Context, document *Document) (string, error) {
 	logger := logging.FromContext(ctx)
 
-	dataBytes, err := json.Marshal(data)
+	dataBytes, err := json.Marshal(document)
 	if err != nil {
 		logger.Error("failed to marshal secrets for checksum calculation", "error", err)
 		return "", err
 	}
 
-	hash := sha256.Sum256(dataBytes)
-	checksum := fmt.Sprintf("%x", hash)
+	return p.Hash(dataBytes), nil
+}
+
+func (p *Pusher) Hasher() hash.Hash {
+	aesCipher, ok := p.Cipher.(*AESCipher)
+	if !ok {
+		return sha256.New()
+	}
+	return hmac.New(sha256.New, aesCipher.ChecksumKey)
+}
 
-	return checksum, nil
+func (p *Pusher) Hash(data []byte) string {
+	hasher := p.Hasher()
+	hasher.Write(data)
+	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
 
-func (p *Pusher) Upload(ctx context.Context, data map[string]any) error {
+func (p *Pusher) StoresChecksums() bool {
+	_, ok := p.Cipher.(*AgeCipher)
+	return !ok && len(p.SOPSRecipients) == 0
+}
+
+func (p *Pusher) RemoteMetadata(ctx context.Context) (map[string]string, error) {
 	logger := logging.FromContext(ctx)
 
-	bucket, path, err := ParseStoragePath(p.StoragePath)
+	info, err := p.Storage.Stat(ctx, p.StorageKey)
+	if errors.Is(err, ErrNotFound) {
+		p.GenerationKnown = true
+		p.LastGeneration = ""
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		logger.Error("failed to read storage object metadata", "storage-path", p.StoragePath, "error", err)
+		return nil, err
+	}
+
+	p.GenerationKnown = true
+	p.LastGeneration = info.Generation
+	return info.Metadata, nil
+}
+
+func (p *Pusher) ResetRemoteState() {
+	p.AppChecksums = nil
+	p.AppGenerations = nil
+	p.GenerationKnown = false
+	p.LastChecksum = ""
+	p.LastFingerprint = ""
+	p.LastGeneration = ""
+}
+
+func (p *Pusher) Verify(ctx context.Context, key string, expected []byte) error {
+	if !p.VerifyUploads {
+		return nil
+	}
+
+	reader, err := p.Storage.Read(ctx, key)
 	if err != nil {
-		logger.Error("failed to parse storage path", "storage-path", p.StoragePath, "error", err)
 		return err
 	}
+	defer reader.Close()
 
-	dataBytes, err := yaml.Marshal(data)
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
 	if err != nil {
-		logger.Error("failed to marshal secrets to YAML", "error", err)
 		return err
 	}
 
-	reader := bytes.NewReader(dataBytes)
-	writer := p.Storage.Bucket(bucket).Object(path).NewWriter(ctx)
-	defer writer.Close()
+	actual := hash.Sum(nil)
+	if !bytes.Equal(expected, actual) {
+		return fmt.Errorf("uploaded object %s does not match (expected sha256 %x, got %x)", key, expected, actual)
+	}
+	return nil
+}
 
-	_, err = io.Copy(writer, reader)
+func (p *Pusher) RemoteChecksum(ctx context.Context) (string, error) {
+	metadata, err := p.RemoteMetadata(ctx)
 	if err != nil {
-		logger.Error("failed to upload to cloud storage", "bucket", bucket, "path", path, "error", err)
+		return "", err
+	}
+	return metadata["checksum"], nil
+}
+
+func (p *Pusher) Encode(document *Document) ([]byte, error) {
+	if p.Format == FormatSOPS {
+		return EncryptSOPS(document, p.SOPSRecipients)
+	}
+	return EncodeDocument(p.Format, document)
+}
+
+func (p *Pusher) Upload(ctx context.Context, document *Document, metadata map[string]string) error {
+	logger := logging.FromContext(ctx)
+
+	dataBytes, err := p.Encode(document)
+	if err != nil {
+		logger.Error("failed to encode secrets", "format", p.Format, "error", err)
 		return err
 	}
 
-	return nil
+	metadata["format"] = string(p.Format)
+	return p.UploadData(ctx, dataBytes, metadata)
 }
 
-func (p *Pusher) AuthVault(ctx context.Context) error {
+func (p *Pusher) UploadData(ctx context.Context, dataBytes []byte, metadata map[string]string) error {
 	logger := logging.FromContext(ctx)
 
-	token := p.Token
-	if token == "" {
-		tokenPath := "/var/run/secrets/kubernetes.io/serviceaccount/token"
-		jwtBytes, err := os.ReadFile(tokenPath)
+	var err error
+	if p.Cipher != nil {
+		dataBytes, err = p.Cipher.Encrypt(dataBytes)
 		if err != nil {
-			logger.Error("failed to read service account token", "path", tokenPath, "error", err)
+			logger.Error("failed to encrypt backup", "error", err)
 			return err
 		}
-		jwt := string(jwtBytes)
+	}
 
-		response, err := p.Vault.Auth.KubernetesLogin(ctx, schema.KubernetesLoginRequest{
-			Jwt:  jwt,
-			Role: p.Role,
-		})
+	hash := sha256.Sum256(dataBytes)
+	return p.UploadObject(ctx, bytes.NewReader(dataBytes), int64(len(dataBytes)), hash[:], metadata)
+}
+
+func (p *Pusher) UploadObject(ctx context.Context, source io.ReadSeeker, size int64, hash []byte, metadata map[string]string) error {
+	logger := logging.FromContext(ctx)
+
+	var err error
+	if !p.GenerationKnown {
+		_, err = p.RemoteMetadata(ctx)
 		if err != nil {
-			logger.Error("failed to authenticate with vault using kubernetes", "role", p.Role, "error", err)
 			return err
 		}
+	}
 
-		token = response.Auth.ClientToken
+	_, err = source.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	generation, err := p.Storage.Write(ctx, p.StorageKey, source, size, metadata, p.LastGeneration)
+	if errors.Is(err, ErrPreconditionFailed) {
+		logger.Error("backup was modified concurrently, discarding cached remote state", "storage-path", p.StoragePath, "generation", p.LastGeneration)
+		p.ResetRemoteState()
+		return err
+	}
+	if err != nil {
+		logger.Error("failed to upload to storage", "storage-path", p.StoragePath, "error", err)
+		return err
 	}
+	p.LastGeneration = generation
+	p.Metrics.BytesUploaded.Add(float64(size))
 
-	err := p.Vault.SetToken(token)
+	err = p.Verify(ctx, p.StorageKey, hash)
 	if err != nil {
-		logger.Error("failed to set vault client token", "error", err)
+		logger.Error("failed to verify upload", "storage-path", p.StoragePath, "error", err)
+		p.ResetRemoteState()
 		return err
 	}
 
+	if p.Versioned {
+		version := NewVersion(time.Now())
+		versionKey := VersionKey(p.StorageKey, version)
+		_, err = source.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		_, err = p.Storage.Write(ctx, versionKey, source, size, metadata, "")
+		if err != nil {
+			logger.Error("failed to upload version to storage", "storage-path", p.StoragePath, "version", version, "error", err)
+			return err
+		}
+		p.Metrics.BytesUploaded.Add(float64(size))
+
+		err = p.Verify(ctx, versionKey, hash)
+		if err != nil {
+			logger.Error("failed to verify uploaded version", "version", version, "error", err)
+			return err
+		}
+		logger.Debug("uploaded backup version", "version", version)
+
+		err = p.PruneVersions(ctx)
+		if err != nil {
+			logger.Warn("failed to prune backup versions", "error", err)
+		}
+	}
+
 	return nil
 }
 
+func (p *Pusher) Changed(ctx context.Context, checksum string) (bool, error) {
+	logger := logging.FromContext(ctx)
+
+	if p.LastChecksum == "" {
+		logger.Debug("fetching remote checksum")
+		remoteChecksum, err := p.RemoteChecksum(ctx)
+		if err != nil {
+			logger.Error("failed to fetch remote checksum", "error", err)
+			return false, err
+		}
+		p.LastChecksum = remoteChecksum
+	}
+
+	if checksum == p.LastChecksum {
+		return false, nil
+	}
+	logger.Debug("backup changed", "previous-checksum", p.LastChecksum, "current-checksum", checksum)
+	return true, nil
+}
+
+func (p *Pusher) AuthVault(ctx context.Context) error {
+	return p.Auth.Login(ctx, p.Vault)
+}
+
 func (p *Pusher) Push(ctx context.Context) error {
 	logger := logging.FromContext(ctx)
 
@@ -224,15 +756,78 @@ func (p *Pusher) Push(ctx context.Context) error {
 		logger.Error("vault authentication failed", "error", err)
 		return err
 	}
-	defer p.Vault.ClearToken()
+
+	if p.Snapshot {
+		return p.PushSnapshot(ctx)
+	}
+
+	fingerprint := ""
+	if p.ChangeDetection == ChangeDetectionMetadata {
+		if p.LastFingerprint == "" {
+			logger.Debug("fetching remote fingerprint")
+			metadata, err := p.RemoteMetadata(ctx)
+			if err != nil {
+				logger.Error("failed to fetch remote fingerprint", "error", err)
+				return err
+			}
+			p.LastChecksum = metadata["checksum"]
+			p.LastFingerprint = metadata["fingerprint"]
+		}
+
+		logger.Debug("calculating fingerprint")
+		fingerprint, err = p.Fingerprint(ctx)
+		if vault.IsErrorStatus(err, http.StatusForbidden) {
+			logger.Warn("vault denied access, discarding cached token")
+			p.Auth.Reset()
+		}
+		if err != nil {
+			logger.Error("failed to calculate fingerprint", "error", err)
+			return err
+		}
+
+		if fingerprint == p.LastFingerprint && (p.Mirror == nil || p.LastDocument != nil) {
+			logger.Info("secret metadata unchanged, skipping export")
+			p.Metrics.Skips.Inc()
+			if p.Mirror != nil {
+				p.MirrorAndRecord(ctx, p.LastDocument)
+			}
+			return nil
+		}
+		logger.Debug("secret metadata changed", "previous-fingerprint", p.LastFingerprint, "current-fingerprint", fingerprint)
+	}
 
 	logger.Debug("exporting secrets")
 	secrets, err := p.ExportSecrets(ctx)
+	if vault.IsErrorStatus(err, http.StatusForbidden) {
+		logger.Warn("vault denied access, discarding cached token")
+		p.Auth.Reset()
+	}
 	if err != nil {
 		logger.Error("failed to export secrets", "error", err)
 		return err
 	}
 
+	err = p.UploadSecrets(ctx, secrets, fingerprint)
+	if p.Mirror != nil {
+		p.LastDocument = secrets
+		p.MirrorAndRecord(ctx, secrets)
+	}
+	return err
+}
+
+func (p *Pusher) UploadSecrets(ctx context.Context, secrets *Document, fingerprint string) error {
+	logger := logging.FromContext(ctx)
+
+	if p.Layout == LayoutPerApp {
+		err := p.PushApps(ctx, secrets)
+		if err != nil {
+			logger.Error("failed to push apps", "error", err)
+			return err
+		}
+		p.LastFingerprint = fingerprint
+		return nil
+	}
+
 	logger.Debug("calculating checksum")
 	checksum, err := p.Checksum(ctx, secrets)
 	if err != nil {
@@ -240,61 +835,138 @@ func (p *Pusher) Push(ctx context.Context) error {
 		return err
 	}
 
-	if checksum == p.LastChecksum {
+	changed, err := p.Changed(ctx, checksum)
+	if err != nil {
+		return err
+	}
+	if !changed {
 		logger.Info("secrets unchanged, skipping upload")
+		p.LastFingerprint = fingerprint
+		p.Metrics.Skips.Inc()
 		return nil
 	}
-	logger.Debug("secrets changed, uploading", "previous-checksum", p.LastChecksum, "current-checksum", checksum)
-	p.LastChecksum = checksum
 
-	err = p.Upload(ctx, secrets)
+	metadata := map[string]string{}
+	if p.StoresChecksums() {
+		metadata["checksum"] = checksum
+		if fingerprint != "" {
+			metadata["fingerprint"] = fingerprint
+		}
+	}
+	err = p.Upload(ctx, secrets, metadata)
 	if err != nil {
 		logger.Error("failed to upload secrets", "error", err)
 		return err
 	}
+	p.LastChecksum = checksum
+	p.LastFingerprint = fingerprint
+	p.Metrics.Pushes.Inc()
 
 	logger.Info("secrets successfully pushed", "checksum", checksum)
+	p.Notify(ctx, NotificationEventChanged, fmt.Sprintf("secrets pushed to %s (checksum %s)", p.StoragePath, checksum), nil)
+	return nil
+}
+
+func (p *Pusher) PushAndRecord(ctx context.Context) error {
+	err := p.Push(ctx)
+	if err != nil {
+		p.Metrics.RecordFailure(time.Now())
+		p.Notify(ctx, NotificationEventFailed, fmt.Sprintf("push to %s failed: %s", p.StoragePath, err), err)
+		return err
+	}
+	p.Metrics.RecordSuccess(time.Now())
 	return nil
 }
 
 func (p *Pusher) Run(ctx context.Context) error {
 	logger := logging.FromContext(ctx)
-	logger.Info("starting vault push", "vault", p.Address)
+	include, exclude := p.Filter.Strings()
+	logger.Info("starting vault push", "vault", p.Address, "secrets-paths", p.SecretsPaths, "include", include, "exclude", exclude)
 
-	err := p.Push(ctx)
+	if p.Format == FormatDotenv {
+		logger.Warn("dotenv backups are export-only and cannot be read by vault-restore-secrets or vault-diff-secrets")
+	}
+	if !p.StoresChecksums() {
+		logger.Warn("checksums are not stored with age or sops encryption, the first push after every restart uploads the backup again", "versioned", p.Versioned)
+	}
+
+	if p.DryRun {
+		return p.PushDryRun(ctx)
+	}
+
+	if p.RunForever && p.HealthAddress != "" {
+		stopServer := p.StartServer(ctx)
+		defer stopServer()
+	}
+
+	signalChannel := make(chan os.Signal, 1)
+	if p.RunForever {
+		signal.Notify(signalChannel, syscall.SIGHUP)
+		defer signal.Stop(signalChannel)
+	}
+
+	failures := 0
+	err := p.PushAndRecord(ctx)
 	if err != nil {
 		logger.Error("initial push failed", "error", err)
-		return err
+		if !p.RunForever || p.FailFast {
+			return err
+		}
+		failures++
 	}
 
 	if !p.RunForever {
 		return nil
 	}
 
-	logger.Info("entering continuous push loop", "interval", p.Interval)
+	delay := p.NextDelay(time.Now())
+	if failures > 0 {
+		delay = p.Retry.Delay(failures)
+	}
+
+	logger.Info("entering continuous push loop", "interval", p.Interval, "schedule", p.Schedule != nil, "next-push", time.Now().Add(delay))
 
-	ticker := time.NewTicker(p.Interval)
-	defer ticker.Stop()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 
 	running := true
 	pushCount := 0
 	for running {
 		select {
-		case <-ticker.C:
-			pushCount++
-			logger.Debug("executing scheduled push", "push-number", pushCount)
-			err := p.Push(ctx)
-			if err != nil {
-				logger.Error("push failed", "push-number", pushCount, "error", err)
+		case <-timer.C:
+			logger.Debug("executing scheduled push", "push-number", pushCount+1, "consecutive-failures", failures)
+		case sig := <-signalChannel:
+			if sig != syscall.SIGHUP {
+				logger.Info("shutdown signal received", "signal", sig)
+				running = false
+				continue
+			}
+			logger.Info("manual push requested", "signal", sig)
+			timer.Stop()
+		}
+
+		pushCount++
+		err := p.PushAndRecord(ctx)
+		if err != nil {
+			failures++
+			logger.Error("push failed", "push-number", pushCount, "consecutive-failures", failures, "error", err)
+			if p.Retry.MaxFailures > 0 && failures >= p.Retry.MaxFailures {
+				logger.Error("too many consecutive failures, giving up", "consecutive-failures", failures)
 				return err
 			}
-		case sig := <-signalChannel:
-			logger.Info("shutdown signal received", "signal", sig)
-			running = false
+			delay = p.Retry.Delay(failures)
+			logger.Info("retrying push", "delay", delay)
+		} else {
+			if failures > 0 {
+				logger.Info("push recovered", "consecutive-failures", failures)
+			}
+			failures = 0
+			delay = p.NextDelay(time.Now())
+			logger.Debug("next push scheduled", "next-push", time.Now().Add(delay))
 		}
+		timer.Reset(delay)
 	}
 
 	logger.Info("vault push shutdown complete")
@@ -0,0 +1,419 @@
+package vaultpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+type RestoreMode string
+
+const (
+	RestoreModeSkip      RestoreMode = "skip"
+	RestoreModeOverwrite RestoreMode = "overwrite"
+)
+
+type RestorerOpts struct {
+	Address                string
+	AgeIdentityPath        string
+	Apps                   []string
+	Auth                   AuthOpts
+	Config                 bool
+	EncryptionKeyPath      string
+	Layout                 Layout
+	Mode                   RestoreMode
+	SecretsPath            string
+	StoragePath            string
+	StorageCredentialsPath string
+	Version                string
+}
+
+type Restorer struct {
+	Address     string
+	Apps        []string
+	Auth        *Auth
+	Cipher      Cipher
+	Config      bool
+	Layout      Layout
+	Mode        RestoreMode
+	SecretsPath string
+	Storage     Storage
+	StorageKey  string
+	StoragePath string
+	Vault       *vault.Client
+	Version     string
+}
+
+func NewRestorer(opts *RestorerOpts) (*Restorer, error) {
+	if opts.Address == "" {
+		return nil, fmt.Errorf("address unset")
+	}
+
+	cipher, err := NewCipher(&CipherOpts{
+		AgeIdentityPath: opts.AgeIdentityPath,
+		KeyPath:         opts.EncryptionKeyPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = RestoreModeSkip
+	}
+	if mode != RestoreModeSkip && mode != RestoreModeOverwrite {
+		return nil, fmt.Errorf("invalid restore mode %s", mode)
+	}
+
+	layout := opts.Layout
+	if layout == "" {
+		layout = LayoutSingle
+	}
+	if layout != LayoutSingle && layout != LayoutPerApp {
+		return nil, fmt.Errorf("invalid layout %s", layout)
+	}
+	if layout == LayoutPerApp && opts.Version != "" {
+		return nil, fmt.Errorf("per-app layout cannot be combined with backup version")
+	}
+
+	auth, err := NewAuth(&opts.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	storageLocation, err := ParseStoragePath(opts.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := NewStorage(context.Background(), storageLocation, opts.StorageCredentialsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	vaultClient, err := vault.New(
+		vault.WithAddress(opts.Address),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	restorer := Restorer{
+		Address:     opts.Address,
+		Apps:        opts.Apps,
+		Auth:        auth,
+		Cipher:      cipher,
+		Config:      opts.Config,
+		Layout:      layout,
+		Mode:        mode,
+		SecretsPath: NormalizeMount(opts.SecretsPath),
+		Storage:     storage,
+		StorageKey:  storageLocation.Key,
+		StoragePath: opts.StoragePath,
+		Vault:       vaultClient,
+		Version:     opts.Version,
+	}
+	return &restorer, nil
+}
+
+func (r *Restorer) AuthVault(ctx context.Context) error {
+	return r.Auth.Login(ctx, r.Vault)
+}
+
+func (r *Restorer) Download(ctx context.Context) (*Document, error) {
+	var document *Document
+	var err error
+	if r.Layout == LayoutPerApp {
+		document, err = r.DownloadApps(ctx)
+	} else {
+		key := r.StorageKey
+		if r.Version != "" {
+			key = VersionKey(r.StorageKey, r.Version)
+		}
+		document, err = r.DownloadObject(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.MapSecretsPath(ctx, document)
+}
+
+func (r *Restorer) MapSecretsPath(ctx context.Context, document *Document) (*Document, error) {
+	logger := logging.FromContext(ctx)
+
+	if r.SecretsPath == "" {
+		return document, nil
+	}
+
+	mount, ok := document.Mounts[r.SecretsPath]
+	if ok {
+		if len(document.Mounts) > 1 {
+			logger.Info("restricting backup to secrets path", "secrets-path", r.SecretsPath)
+		}
+		document.Mounts = map[string]*Mount{r.SecretsPath: mount}
+		return document, nil
+	}
+
+	if len(document.Mounts) != 1 {
+		mountNames := sortedKeys(document.Mounts)
+		logger.Error("secrets path not found in backup", "secrets-path", r.SecretsPath, "mounts", mountNames)
+		return nil, fmt.Errorf("backup contains mounts %s, secrets path %s must name one of them", strings.Join(mountNames, ", "), r.SecretsPath)
+	}
+
+	for mountName, mount := range document.Mounts {
+		logger.Info("mapping backup mount onto secrets path", "mount", mountName, "secrets-path", r.SecretsPath)
+		document.Mounts = map[string]*Mount{r.SecretsPath: mount}
+	}
+	return document, nil
+}
+
+func (r *Restorer) DownloadObject(ctx context.Context, key string) (*Document, error) {
+	logger := logging.FromContext(ctx)
+
+	info, err := r.Storage.Stat(ctx, key)
+	if err != nil {
+		logger.Error("failed to read storage object metadata", "storage-path", r.StoragePath, "version", r.Version, "error", err)
+		return nil, err
+	}
+	format := Format(info.Metadata["format"])
+	if format == "" {
+		format = FormatYAML
+	}
+
+	reader, err := r.Storage.Read(ctx, key)
+	if err != nil {
+		logger.Error("failed to download from storage", "storage-path", r.StoragePath, "version", r.Version, "error", err)
+		return nil, err
+	}
+	defer reader.Close()
+
+	dataBytes, err := io.ReadAll(reader)
+	if err != nil {
+		logger.Error("failed to download from storage", "storage-path", r.StoragePath, "version", r.Version, "error", err)
+		return nil, err
+	}
+
+	if format == FormatSOPS {
+		ageCipher, ok := r.Cipher.(*AgeCipher)
+		if !ok {
+			logger.Error("backup is sops encrypted but no age identity configured")
+			return nil, fmt.Errorf("backup is sops encrypted")
+		}
+
+		document, err := DecryptSOPS(dataBytes, ageCipher.Identities)
+		if err != nil {
+			logger.Error("failed to decrypt sops secrets", "error", err)
+			return nil, err
+		}
+		return document, nil
+	}
+
+	if r.Cipher != nil {
+		dataBytes, err = r.Cipher.Decrypt(dataBytes)
+		if err != nil {
+			logger.Error("failed to decrypt secrets", "error", err)
+			return nil, err
+		}
+	} else if IsEncrypted(dataBytes) {
+		logger.Error("backup is encrypted but no decryption key configured")
+		return nil, fmt.Errorf("backup is encrypted")
+	}
+
+	document, err := DecodeDocument(format, dataBytes)
+	if errors.Is(err, ErrLegacyDocument) {
+		if r.SecretsPath == "" {
+			logger.Error("backup uses the legacy format, a secrets path is required to restore it")
+			return nil, err
+		}
+		logger.Warn("backup uses the legacy format, mapping onto secrets path", "secrets-path", r.SecretsPath)
+		document, err = DecodeLegacyDocument(format, dataBytes, r.SecretsPath)
+	}
+	if err != nil {
+		logger.Error("failed to decode secrets", "format", format, "error", err)
+		return nil, err
+	}
+
+	return document, nil
+}
+
+func (r *Restorer) SelectApps(ctx context.Context, document *Document) ([]string, error) {
+	logger := logging.FromContext(ctx)
+
+	all := document.Apps()
+	if len(r.Apps) == 0 {
+		return all, nil
+	}
+
+	apps := []string{}
+	for _, selector := range r.Apps {
+		matches := []string{}
+		for _, app := range all {
+			if matchesSelectors([]string{selector}, app) {
+				matches = append(matches, app)
+			}
+		}
+
+		if len(matches) == 0 {
+			logger.Error("app not found in backup", "app", selector)
+			return nil, fmt.Errorf("app %s not found in backup", selector)
+		}
+
+		for _, app := range matches {
+			if !slices.Contains(apps, app) {
+				apps = append(apps, app)
+			}
+		}
+	}
+	return apps, nil
+}
+
+func (r *Restorer) CurrentVersion(ctx context.Context, mount string, version int, path string) (int64, bool, error) {
+	if version == 1 {
+		_, err := r.Vault.Secrets.KvV1Read(ctx, path, vault.WithMountPath(mount))
+		if vault.IsErrorStatus(err, http.StatusNotFound) {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		return 0, true, nil
+	}
+
+	response, err := r.Vault.Secrets.KvV2ReadMetadata(ctx, path, vault.WithMountPath(mount))
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return response.Data.CurrentVersion, true, nil
+}
+
+func (r *Restorer) WriteSecret(ctx context.Context, mount string, version int, path string, secret *Secret, currentVersion int64) error {
+	if version == 1 {
+		_, err := r.Vault.Secrets.KvV1Write(ctx, path, secret.Data, vault.WithMountPath(mount))
+		return err
+	}
+
+	history := []map[string]any{}
+	for _, secretVersion := range secret.Versions {
+		if secretVersion.Data != nil {
+			history = append(history, secretVersion.Data)
+		}
+	}
+	history = append(history, secret.Data)
+
+	for _, data := range history {
+		request := schema.KvV2WriteRequest{
+			Data:    data,
+			Options: map[string]any{"cas": currentVersion},
+		}
+		response, err := r.Vault.Secrets.KvV2Write(ctx, path, request, vault.WithMountPath(mount))
+		if err != nil {
+			return err
+		}
+		currentVersion = response.Data.Version
+	}
+
+	if secret.Metadata != nil {
+		request := schema.KvV2WriteMetadataRequest{
+			CasRequired:        secret.Metadata.CasRequired,
+			CustomMetadata:     secret.Metadata.CustomMetadata,
+			DeleteVersionAfter: secret.Metadata.DeleteVersionAfter,
+			MaxVersions:        int32(secret.Metadata.MaxVersions),
+		}
+		_, err := r.Vault.Secrets.KvV2WriteMetadata(ctx, path, request, vault.WithMountPath(mount))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Restorer) Restore(ctx context.Context, document *Document) error {
+	logger := logging.FromContext(ctx)
+
+	apps, err := r.SelectApps(ctx, document)
+	if err != nil {
+		return err
+	}
+
+	if r.Config {
+		if document.Config == nil {
+			logger.Error("backup does not contain vault config")
+			return fmt.Errorf("backup does not contain vault config")
+		}
+
+		err = r.WriteConfig(ctx, document.Config)
+		if err != nil {
+			return err
+		}
+	}
+
+	restored := 0
+	skipped := 0
+	for _, app := range apps {
+		mountName, path, _ := document.SplitApp(app)
+		mount := document.Mounts[mountName]
+
+		currentVersion, exists, err := r.CurrentVersion(ctx, mountName, mount.Version, path)
+		if err != nil {
+			logger.Error("failed to check if secret exists", "app", app, "error", err)
+			return err
+		}
+		if exists && r.Mode == RestoreModeSkip {
+			logger.Info("secret exists, skipping", "app", app)
+			skipped++
+			continue
+		}
+
+		err = r.WriteSecret(ctx, mountName, mount.Version, path, mount.Secrets[path], currentVersion)
+		if err != nil {
+			logger.Error("failed to write secret", "app", app, "error", err)
+			return err
+		}
+		logger.Debug("secret restored", "app", app)
+		restored++
+	}
+
+	logger.Info("secrets successfully restored", "restored", restored, "skipped", skipped)
+	return nil
+}
+
+func (r *Restorer) Run(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	logger.Info("starting vault restore", "vault", r.Address, "mode", r.Mode, "version", r.Version)
+
+	logger.Debug("downloading secrets")
+	document, err := r.Download(ctx)
+	if err != nil {
+		logger.Error("failed to download secrets", "error", err)
+		return err
+	}
+
+	logger.Debug("authenticating with vault")
+	err = r.AuthVault(ctx)
+	if err != nil {
+		logger.Error("vault authentication failed", "error", err)
+		return err
+	}
+	defer r.Vault.ClearToken()
+
+	logger.Debug("restoring secrets")
+	err = r.Restore(ctx, document)
+	if err != nil {
+		logger.Error("failed to restore secrets", "error", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package vaultpush
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+type Retry struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	MaxFailures  int
+}
+
+func (r Retry) Delay(failures int) time.Duration {
+	delay := r.InitialDelay
+	for range failures - 1 {
+		delay *= 2
+		if delay >= r.MaxDelay {
+			break
+		}
+	}
+	delay = min(delay, r.MaxDelay)
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(delay-half+1)
+}
@@ -0,0 +1,152 @@
+package vaultpush
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Storage struct {
+	Bucket string
+	Client *minio.Client
+}
+
+func NewS3Storage(ctx context.Context, location *StorageLocation, credentialsPath string) (*S3Storage, error) {
+	endpoint := location.Options.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	secure := true
+	if value := location.Options.Get("insecure"); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		secure = !insecure
+	}
+
+	profile := location.Options.Get("profile")
+
+	var creds *credentials.Credentials
+	if credentialsPath != "" {
+		creds = credentials.NewFileAWSCredentials(credentialsPath, profile)
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{},
+		})
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Region: location.Options.Get("region"),
+		Secure: secure,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s3Storage := S3Storage{
+		Bucket: location.Bucket,
+		Client: client,
+	}
+	return &s3Storage, nil
+}
+
+func (s *S3Storage) CheckWrite(ctx context.Context, key string) error {
+	exists, err := s.Client.BucketExists(ctx, s.Bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", s.Bucket)
+	}
+
+	core := minio.Core{Client: s.Client}
+	uploadID, err := core.NewMultipartUpload(ctx, s.Bucket, key, minio.PutObjectOptions{})
+	if err != nil {
+		return err
+	}
+	return core.AbortMultipartUpload(ctx, s.Bucket, key, uploadID)
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.Client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	for object := range s.Client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+func (s *S3Storage) Read(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.Client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = object.Stat()
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		object.Close()
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+	return object, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := s.Client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	for key, value := range info.UserMetadata {
+		metadata[strings.ToLower(key)] = value
+	}
+
+	objectInfo := ObjectInfo{
+		Generation: info.ETag,
+		Metadata:   metadata,
+	}
+	return &objectInfo, nil
+}
+
+func (s *S3Storage) Write(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string, generation string) (string, error) {
+	options := minio.PutObjectOptions{DisableMultipart: true, UserMetadata: metadata}
+	switch generation {
+	case GenerationAny:
+	case "":
+		options.SetMatchETagExcept("*")
+	default:
+		options.SetMatchETag(generation)
+	}
+
+	info, err := s.Client.PutObject(ctx, s.Bucket, key, reader, size, options)
+	if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+		return "", ErrPreconditionFailed
+	}
+	if err != nil {
+		return "", err
+	}
+	return info.ETag, nil
+}
@@ -0,0 +1,51 @@
+package vaultpush
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+)
+
+func ParseSchedule(expression string, timezone string) (cron.Schedule, *time.Location, error) {
+	location := time.Local
+	if timezone != "" {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid timezone %s: %w", timezone, err)
+		}
+	}
+
+	if expression == "" {
+		return nil, location, nil
+	}
+
+	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	schedule, err := parser.Parse(expression)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid schedule %s: %w", expression, err)
+	}
+	return schedule, location, nil
+}
+
+func (p *Pusher) Period(now time.Time) time.Duration {
+	if p.Schedule == nil {
+		return p.Interval
+	}
+	next := p.Schedule.Next(now.In(p.Location))
+	return p.Schedule.Next(next).Sub(next)
+}
+
+func (p *Pusher) NextDelay(now time.Time) time.Duration {
+	delay := p.Interval
+	if p.Schedule != nil {
+		delay = p.Schedule.Next(now.In(p.Location)).Sub(now)
+	}
+	if p.Jitter > 0 {
+		delay += rand.N(p.Jitter + 1)
+	}
+	return delay
+}
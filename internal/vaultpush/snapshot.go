@@ -0,0 +1,135 @@
+package vaultpush
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+	"github.com/hashicorp/vault-client-go"
+)
+
+type Snapshot struct {
+	Checksum   string
+	File       *os.File
+	Hash       []byte
+	Size       int64
+	UploadSize int64
+}
+
+func (s *Snapshot) Close() error {
+	s.File.Close()
+	return os.Remove(s.File.Name())
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (p *Pusher) EncryptStream(writer io.Writer) (io.WriteCloser, error) {
+	if p.Cipher == nil {
+		return nopWriteCloser{Writer: writer}, nil
+	}
+	return p.Cipher.EncryptStream(writer)
+}
+
+func (p *Pusher) ReadSnapshot(ctx context.Context) (*Snapshot, error) {
+	response, err := p.Vault.ReadRaw(ctx, "sys/storage/raft/snapshot")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, &vault.ResponseError{StatusCode: response.StatusCode}
+	}
+
+	file, err := os.CreateTemp("", "vault-snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+	snapshot := Snapshot{File: file}
+
+	uploadHash := sha256.New()
+	writer, err := p.EncryptStream(io.MultiWriter(file, uploadHash))
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+
+	hasher := p.Hasher()
+	snapshot.Size, err = io.Copy(writer, io.TeeReader(response.Body, hasher))
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+
+	snapshot.UploadSize, err = file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+
+	snapshot.Checksum = fmt.Sprintf("%x", hasher.Sum(nil))
+	snapshot.Hash = uploadHash.Sum(nil)
+	return &snapshot, nil
+}
+
+func (p *Pusher) PushSnapshot(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+
+	logger.Debug("reading raft snapshot")
+	snapshot, err := p.ReadSnapshot(ctx)
+	if vault.IsErrorStatus(err, http.StatusForbidden) {
+		logger.Warn("vault denied access, discarding cached token")
+		p.Auth.Reset()
+	}
+	if err != nil {
+		logger.Error("failed to read raft snapshot", "error", err)
+		return err
+	}
+	defer snapshot.Close()
+
+	checksum := snapshot.Checksum
+	size := snapshot.Size
+
+	changed, err := p.Changed(ctx, checksum)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		logger.Info("snapshot unchanged, skipping upload", "size", size)
+		p.Metrics.Skips.Inc()
+		return nil
+	}
+
+	metadata := map[string]string{"size": strconv.FormatInt(size, 10)}
+	if p.StoresChecksums() {
+		metadata["checksum"] = checksum
+	}
+	err = p.UploadObject(ctx, snapshot.File, snapshot.UploadSize, snapshot.Hash, metadata)
+	if err != nil {
+		logger.Error("failed to upload snapshot", "error", err)
+		return err
+	}
+	p.LastChecksum = checksum
+	p.Metrics.Pushes.Inc()
+
+	logger.Info("snapshot successfully pushed", "checksum", checksum, "size", size)
+	p.Notify(ctx, NotificationEventChanged, fmt.Sprintf("snapshot pushed to %s (checksum %s, %d bytes)", p.StoragePath, checksum, size), nil)
+	return nil
+}
@@ -0,0 +1,138 @@
+package vaultpush
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"filippo.io/age"
+	"github.com/getsops/sops/v3"
+	sopsaes "github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	sopsconfig "github.com/getsops/sops/v3/config"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	sopsversion "github.com/getsops/sops/v3/version"
+	"github.com/goccy/go-yaml"
+)
+
+func sopsStore() *sopsyaml.Store {
+	return sopsyaml.NewStore(&sopsconfig.YAMLStoreConfig{})
+}
+
+func EncryptSOPS(document *Document, recipients []age.Recipient) ([]byte, error) {
+	plainBytes, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	store := sopsStore()
+	branches, err := store.LoadPlainFile(plainBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyGroup := sops.KeyGroup{}
+	for _, recipient := range recipients {
+		x25519Recipient, ok := recipient.(*age.X25519Recipient)
+		if !ok {
+			return nil, fmt.Errorf("sops format requires x25519 age recipients")
+		}
+		masterKey, err := sopsage.MasterKeyFromRecipient(x25519Recipient.String())
+		if err != nil {
+			return nil, err
+		}
+		err = masterKey.Encrypt(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		keyGroup = append(keyGroup, masterKey)
+	}
+	if len(keyGroup) == 0 {
+		return nil, fmt.Errorf("age recipients unset")
+	}
+
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:         []sops.KeyGroup{keyGroup},
+			UnencryptedSuffix: sops.DefaultUnencryptedSuffix,
+			Version:           sopsversion.Version,
+		},
+	}
+
+	cipher := sopsaes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+
+	return store.EmitEncryptedFile(tree)
+}
+
+func DecryptSOPS(data []byte, identities []age.Identity) (*Document, error) {
+	store := sopsStore()
+	tree, err := store.LoadEncryptedFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := sopsDataKey(tree.Metadata, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher := sopsaes.NewCipher()
+	mac, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedMac, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sops mac: %w", err)
+	}
+	if expectedMac != mac {
+		return nil, fmt.Errorf("sops mac mismatch")
+	}
+
+	plainBytes, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, err
+	}
+	document := Document{}
+	err = yaml.Unmarshal(plainBytes, &document)
+	if err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+func sopsDataKey(metadata sops.Metadata, identities []age.Identity) ([]byte, error) {
+	parsedIdentities := sopsage.ParsedIdentities(identities)
+	for _, keyGroup := range metadata.KeyGroups {
+		for _, key := range keyGroup {
+			ageKey, ok := key.(*sopsage.MasterKey)
+			if !ok {
+				continue
+			}
+			parsedIdentities.ApplyToMasterKey(ageKey)
+			dataKey, err := ageKey.Decrypt()
+			if err == nil {
+				return dataKey, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no age identity can decrypt the sops data key")
+}
@@ -0,0 +1,95 @@
+package vaultpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+var ErrPreconditionFailed = errors.New("object generation precondition failed")
+
+const GenerationAny = "*"
+
+type ObjectInfo struct {
+	Generation string
+	Metadata   map[string]string
+}
+
+type Storage interface {
+	CheckWrite(ctx context.Context, key string) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+	Read(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Write(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string, generation string) (string, error)
+}
+
+type StorageLocation struct {
+	Bucket  string
+	Key     string
+	Options url.Values
+	Scheme  string
+}
+
+func ParseStoragePath(storagePath string) (*StorageLocation, error) {
+	parsed, err := url.Parse(storagePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage path %s: %w", storagePath, err)
+	}
+
+	key := strings.TrimPrefix(parsed.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		return nil, fmt.Errorf("invalid storage path %s", storagePath)
+	}
+
+	switch parsed.Scheme {
+	case "gs", "s3":
+		if parsed.Host == "" {
+			return nil, fmt.Errorf("invalid storage path %s: bucket unset", storagePath)
+		}
+	case "file":
+		if parsed.Host != "" {
+			return nil, fmt.Errorf("invalid storage path %s: file paths must be absolute", storagePath)
+		}
+	default:
+		return nil, fmt.Errorf("invalid storage path %s: unsupported scheme %s", storagePath, parsed.Scheme)
+	}
+
+	location := StorageLocation{
+		Bucket:  parsed.Host,
+		Key:     key,
+		Options: parsed.Query(),
+		Scheme:  parsed.Scheme,
+	}
+	return &location, nil
+}
+
+func NewStorage(ctx context.Context, location *StorageLocation, credentialsPath string) (Storage, error) {
+	switch location.Scheme {
+	case "gs":
+		gcsStorage, err := NewGCSStorage(ctx, location, credentialsPath)
+		if err != nil {
+			return nil, err
+		}
+		return gcsStorage, nil
+	case "s3":
+		s3Storage, err := NewS3Storage(ctx, location, credentialsPath)
+		if err != nil {
+			return nil, err
+		}
+		return s3Storage, nil
+	case "file":
+		fileStorage, err := NewFileStorage(ctx, location, credentialsPath)
+		if err != nil {
+			return nil, err
+		}
+		return fileStorage, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage scheme %s", location.Scheme)
+	}
+}
@@ -0,0 +1,108 @@
+package vaultpush
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benfiola/homelab-helper/internal/logging"
+)
+
+const versionTimeFormat = "20060102T150405.000Z"
+
+type Retention struct {
+	Daily int
+	Last  int
+}
+
+func VersionPrefix(key string) string {
+	return key + ".versions/"
+}
+
+func VersionKey(key string, version string) string {
+	return VersionPrefix(key) + version
+}
+
+func NewVersion(now time.Time) string {
+	return now.UTC().Format(versionTimeFormat)
+}
+
+func ListVersions(ctx context.Context, storage Storage, key string) ([]string, error) {
+	prefix := VersionPrefix(key)
+	keys, err := storage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []string{}
+	for _, versionKey := range keys {
+		version := strings.TrimPrefix(versionKey, prefix)
+		_, err := time.Parse(versionTimeFormat, version)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+	return versions, nil
+}
+
+func (r Retention) Prunable(versions []string, now time.Time) []string {
+	if r.Daily == 0 && r.Last == 0 {
+		return []string{}
+	}
+
+	keep := map[string]bool{}
+	for index, version := range versions {
+		if index < r.Last {
+			keep[version] = true
+		}
+	}
+
+	cutoff := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-r.Daily)
+	days := map[string]bool{}
+	for _, version := range versions {
+		versionTime, err := time.Parse(versionTimeFormat, version)
+		if err != nil || r.Daily == 0 || versionTime.Before(cutoff) {
+			continue
+		}
+		day := versionTime.Format(time.DateOnly)
+		if days[day] {
+			continue
+		}
+		days[day] = true
+		keep[version] = true
+	}
+
+	prunable := []string{}
+	for _, version := range versions {
+		if !keep[version] {
+			prunable = append(prunable, version)
+		}
+	}
+	return prunable
+}
+
+func (p *Pusher) PruneVersions(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+
+	versions, err := ListVersions(ctx, p.Storage, p.StorageKey)
+	if err != nil {
+		logger.Error("failed to list backup versions", "storage-path", p.StoragePath, "error", err)
+		return err
+	}
+
+	prunable := p.Retention.Prunable(versions, time.Now())
+	for _, version := range prunable {
+		err := p.Storage.Delete(ctx, VersionKey(p.StorageKey, version))
+		if err != nil {
+			logger.Error("failed to delete backup version", "version", version, "error", err)
+			return err
+		}
+		logger.Debug("deleted backup version", "version", version)
+	}
+
+	logger.Info("pruned backup versions", "kept", len(versions)-len(prunable), "deleted", len(prunable))
+	return nil
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,17 +16,28 @@ import (
 	"github.com/hashicorp/vault-client-go/schema"
 )
 
+type SealState string
+
+const (
+	SealStateSealed      SealState = "sealed"
+	SealStateUnreachable SealState = "unreachable"
+	SealStateUnsealed    SealState = "unsealed"
+)
+
 type Opts struct {
-	Address       string
-	RunForever    *bool
-	UnsealKeyPath string
+	Address        string
+	Interval       time.Duration
+	RunForever     *bool
+	UnsealKeyPaths []string
 }
 
 type Unsealer struct {
-	Address       string
-	Vault         *vault.Client
-	RunForever    bool
-	UnsealKeyPath string
+	Address        string
+	Interval       time.Duration
+	Vault          *vault.Client
+	RunForever     bool
+	State          SealState
+	UnsealKeyPaths []string
 }
 
 func New(opts *Opts) (*Unsealer, error) {
@@ -31,13 +45,29 @@ func New(opts *Opts) (*Unsealer, error) {
 		return nil, fmt.Errorf("address unset")
 	}
 
+	interval := opts.Interval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid interval %s", interval)
+	}
+
 	runForever := true
 	if opts.RunForever != nil {
 		runForever = *opts.RunForever
 	}
 
-	if opts.UnsealKeyPath == "" {
-		return nil, fmt.Errorf("unseal key path unset")
+	unsealKeyPaths := []string{}
+	for _, unsealKeyPath := range opts.UnsealKeyPaths {
+		unsealKeyPath = strings.TrimSpace(unsealKeyPath)
+		if unsealKeyPath == "" {
+			continue
+		}
+		unsealKeyPaths = append(unsealKeyPaths, unsealKeyPath)
+	}
+	if len(unsealKeyPaths) == 0 {
+		return nil, fmt.Errorf("unseal key paths unset")
 	}
 
 	vaultClient, err := vault.New(
@@ -48,10 +78,11 @@ func New(opts *Opts) (*Unsealer, error) {
 	}
 
 	unsealer := Unsealer{
-		Address:       opts.Address,
-		RunForever:    runForever,
-		UnsealKeyPath: opts.UnsealKeyPath,
-		Vault:         vaultClient,
+		Address:        opts.Address,
+		Interval:       interval,
+		RunForever:     runForever,
+		UnsealKeyPaths: unsealKeyPaths,
+		Vault:          vaultClient,
 	}
 	return &unsealer, nil
 }
@@ -59,13 +90,71 @@ func New(opts *Opts) (*Unsealer, error) {
 func (u *Unsealer) WaitForPath(ctx context.Context) error {
 	logger := logging.FromContext(ctx)
 
-	for {
-		_, err := os.Lstat(u.UnsealKeyPath)
-		if err == nil {
-			return nil
+	for _, unsealKeyPath := range u.UnsealKeyPaths {
+		for {
+			_, err := os.Lstat(unsealKeyPath)
+			if err == nil {
+				break
+			}
+			logger.Debug("waiting for unseal key file", "path", unsealKeyPath)
+			err = Sleep(ctx, 1*time.Second)
+			if err != nil {
+				return err
+			}
 		}
-		logger.Debug("waiting for unseal key file", "path", u.UnsealKeyPath)
-		time.Sleep(1 * time.Second)
+	}
+	return nil
+}
+
+func (u *Unsealer) ReadUnsealKeys(ctx context.Context) ([]string, error) {
+	unsealKeys := []string{}
+	for _, unsealKeyPath := range u.UnsealKeyPaths {
+		info, err := os.Stat(unsealKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		files := []string{unsealKeyPath}
+		if info.IsDir() {
+			entries, err := os.ReadDir(unsealKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			files = []string{}
+			for _, entry := range entries {
+				if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+					continue
+				}
+				files = append(files, filepath.Join(unsealKeyPath, entry.Name()))
+			}
+		}
+
+		for _, file := range files {
+			unsealKeyBytes, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			unsealKey := strings.TrimSpace(string(unsealKeyBytes))
+			if unsealKey == "" || slices.Contains(unsealKeys, unsealKey) {
+				continue
+			}
+			unsealKeys = append(unsealKeys, unsealKey)
+		}
+	}
+
+	if len(unsealKeys) == 0 {
+		return nil, fmt.Errorf("no unseal keys found")
+	}
+	return unsealKeys, nil
+}
+
+func (u *Unsealer) ResetUnseal(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	logger.Debug("resetting unseal progress")
+	_, err := u.Vault.System.Unseal(ctx, schema.UnsealRequest{Reset: true})
+	if err != nil {
+		logger.Warn("failed to reset unseal progress", "error", err)
 	}
 }
 
@@ -78,7 +167,22 @@ func (u *Unsealer) WaitForVault(ctx context.Context) error {
 			return nil
 		}
 		logger.Debug("vault not ready, retrying", "address", u.Address)
-		time.Sleep(1 * time.Second)
+		err = Sleep(ctx, 1*time.Second)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func Sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
 }
 
@@ -110,44 +214,111 @@ func (u *Unsealer) Unseal(ctx context.Context) error {
 		return nil
 	}
 
-	logger.Debug("reading unseal key")
-	unsealKeyBytes, err := os.ReadFile(u.UnsealKeyPath)
+	logger.Debug("reading unseal keys")
+	unsealKeys, err := u.ReadUnsealKeys(ctx)
 	if err != nil {
-		logger.Error("failed to read unseal key file", "path", u.UnsealKeyPath, "error", err)
+		logger.Error("failed to read unseal keys", "paths", u.UnsealKeyPaths, "error", err)
 		return err
 	}
-	unsealKey := string(unsealKeyBytes)
 
-	logger.Debug("sending unseal request to vault")
-	_, err = u.Vault.System.Unseal(ctx, schema.UnsealRequest{Key: unsealKey})
+	if response.Data.Progress > 0 {
+		logger.Warn("discarding existing unseal progress", "progress", response.Data.Progress, "threshold", response.Data.T)
+		u.ResetUnseal(ctx)
+	}
+
+	for index, unsealKey := range unsealKeys {
+		logger.Debug("sending unseal request to vault", "share", index+1, "shares", len(unsealKeys))
+		unsealResponse, err := u.Vault.System.Unseal(ctx, schema.UnsealRequest{Key: unsealKey})
+		if err != nil {
+			logger.Error("failed to unseal vault", "share", index+1, "error", err)
+			u.ResetUnseal(ctx)
+			return err
+		}
+		if !unsealResponse.Data.Sealed {
+			logger.Info("vault unsealed successfully", "shares", index+1)
+			return nil
+		}
+		logger.Debug("unseal in progress", "progress", unsealResponse.Data.Progress, "threshold", unsealResponse.Data.T)
+	}
+
+	u.ResetUnseal(ctx)
+	err = fmt.Errorf("vault still sealed after %d unseal key shares (threshold %d)", len(unsealKeys), response.Data.T)
+	logger.Error("failed to unseal vault", "error", err)
+	return err
+}
+
+func (u *Unsealer) SetState(ctx context.Context, state SealState) {
+	logger := logging.FromContext(ctx)
+
+	if state == u.State {
+		return
+	}
+	logger.Info("vault seal state changed", "previous", u.State, "current", state)
+	u.State = state
+}
+
+func (u *Unsealer) Watch(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+
+	logger.Debug("checking vault seal status")
+	response, err := u.Vault.System.SealStatus(ctx)
 	if err != nil {
-		logger.Error("failed to unseal vault", "error", err)
-		return err
+		logger.Debug("failed to check vault seal status", "error", err)
+		u.SetState(ctx, SealStateUnreachable)
+		return
+	}
+	if !response.Data.Sealed {
+		u.SetState(ctx, SealStateUnsealed)
+		return
 	}
+	u.SetState(ctx, SealStateSealed)
 
-	logger.Info("vault unsealed successfully")
-	return nil
+	logger.Info("vault sealed, unsealing")
+	err = u.Unseal(ctx)
+	if err != nil {
+		logger.Error("unseal process failed", "error", err)
+		return
+	}
+	u.SetState(ctx, SealStateUnsealed)
 }
 
 func (u *Unsealer) Run(ctx context.Context) error {
 	logger := logging.FromContext(ctx)
 	logger.Info("starting vault unseal process", "vault", u.Address)
 
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
 	err := u.Unseal(ctx)
+	if ctx.Err() != nil {
+		logger.Info("shutting down")
+		return nil
+	}
 	if err != nil {
 		logger.Error("unseal process failed", "error", err)
-		return err
+		if !u.RunForever {
+			return err
+		}
+		u.State = SealStateSealed
+	} else {
+		u.State = SealStateUnsealed
 	}
 
 	if !u.RunForever {
 		return nil
 	}
 
-	logger.Info("unseal successful, waiting for shutdown signal")
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT)
-	sig := <-signalChannel
-	logger.Info("received signal, shutting down", "signal", sig)
+	logger.Info("watching vault seal status", "interval", u.Interval)
+	ticker := time.NewTicker(u.Interval)
+	defer ticker.Stop()
 
-	return nil
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("shutting down")
+			return nil
+		case <-ticker.C:
+			u.Watch(ctx)
+		}
+	}
 }